@@ -0,0 +1,70 @@
+package mysql
+
+// AuditHooks callbacks reporting the issued and revoked tokens, to ship
+// an audit trail without wrapping every call site, see WithAuditHooks.
+// The hooks run on the goroutine of the call, after its statement
+// succeeded, so they must return quickly: a hook writing to a remote
+// system should hand the event off, to a buffered channel for example.
+// A panicking hook is logged, the call still succeeds.
+type AuditHooks struct {
+	// OnCreate is called after Create, CreateIfAbsent, Update and
+	// CreateBatch stored a row. CreateTx does not call it, the store
+	// does not know whether the caller's transaction commits.
+	OnCreate func(item StoreItem)
+	// OnRemove is called after a Remove* method removed rows, kind being
+	// the column matched: "code", "access", "refresh", "user_id" or
+	// "client_id". Expired rows deleted by the gc are not reported.
+	OnRemove func(kind, value string)
+	// RawTokens passes the tokens and the token data to the hooks as is,
+	// by default they are masked by the redactor
+	RawTokens bool
+}
+
+// tokenKinds the OnRemove kinds whose value is a token
+var tokenKinds = map[string]bool{"code": true, "access": true, "refresh": true}
+
+// auditCreate reports the stored row to the OnCreate hook
+func (s *Store) auditCreate(item *StoreItem) {
+	if s.audit.OnCreate == nil {
+		return
+	}
+
+	event := *item
+	if !s.audit.RawTokens {
+		event.Code = s.redactToken(event.Code)
+		event.Access = s.redactToken(event.Access)
+		event.Refresh = s.redactToken(event.Refresh)
+		event.Data = s.redactToken(event.Data)
+	}
+	defer s.recoverAudit()
+	s.audit.OnCreate(event)
+}
+
+// auditRemove reports the removed token, or user or client id, to the
+// OnRemove hook
+func (s *Store) auditRemove(kind, value string) {
+	if s.audit.OnRemove == nil {
+		return
+	}
+
+	if tokenKinds[kind] && !s.audit.RawTokens {
+		value = s.redactToken(value)
+	}
+	defer s.recoverAudit()
+	s.audit.OnRemove(kind, value)
+}
+
+// redactToken masks a token, leaving an empty one empty
+func (s *Store) redactToken(value string) string {
+	if value == "" {
+		return ""
+	}
+	return s.redactor(value)
+}
+
+// recoverAudit logs a panicking hook instead of failing the call
+func (s *Store) recoverAudit() {
+	if r := recover(); r != nil {
+		s.errorf("audit hook panic: %v", r)
+	}
+}
@@ -0,0 +1,108 @@
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-oauth2/oauth2/v4/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAESGCMCipher_ShouldRoundTrip(t *testing.T) {
+	// ARRANGE
+	c, err := NewAESGCMCipher(bytes.Repeat([]byte("k"), 32))
+	assert.NoError(t, err)
+	plaintext := []byte(`{"Access":"1_1_1"}`)
+
+	// ACTION
+	first, err := c.Encrypt(plaintext)
+	assert.NoError(t, err)
+	second, err := c.Encrypt(plaintext)
+	assert.NoError(t, err)
+	decrypted, err := c.Decrypt(first)
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, plaintext, decrypted)
+	assert.NotEqual(t, first, second)
+	assert.False(t, bytes.Contains(first, plaintext))
+
+	_, err = c.Decrypt(first[:4])
+	assert.Error(t, err)
+}
+
+func TestNewAESGCMCipher_ShouldRejectInvalidKey(t *testing.T) {
+	_, err := NewAESGCMCipher([]byte("short"))
+	assert.Error(t, err)
+}
+
+func TestCreate_ShouldStoreEncryptedData(t *testing.T) {
+	// ARRANGE
+	c, _ := NewAESGCMCipher(bytes.Repeat([]byte("k"), 32))
+	store, mockDB := newMockStore(t, WithCipher(c))
+	defer store.Close()
+
+	info := &models.Token{
+		UserID:          "1_1",
+		Access:          "1_1_1",
+		AccessCreateAt:  time.Now(),
+		AccessExpiresIn: time.Hour,
+	}
+	data := &captureArg{}
+	mockDB.ExpectExec(regexp.QuoteMeta("insert into `oauth2_token`")).
+		WithArgs(sqlmock.AnyArg(), "", "1_1_1", "", data, "1_1").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	// ACTION
+	err := store.Create(context.Background(), info)
+	assert.NoError(t, err)
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE access=? AND expired_at>? LIMIT 1")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "expired_at", "code", "access", "refresh", "data", "user_id"}).
+			AddRow(1, time.Now().Add(time.Hour).Unix(), "", "1_1_1", "", data.value, "1_1"))
+	got, err := store.GetByAccess(context.Background(), "1_1_1")
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, "1_1", got.GetUserID())
+
+	stored := data.value.(string)
+	assert.False(t, strings.Contains(stored, "1_1_1"))
+	_, err = base64.StdEncoding.DecodeString(stored)
+	assert.NoError(t, err)
+}
+
+func TestGetByAccess_ShouldFailWithWrongKey(t *testing.T) {
+	// ARRANGE
+	c, _ := NewAESGCMCipher(bytes.Repeat([]byte("k"), 32))
+	other, _ := NewAESGCMCipher(bytes.Repeat([]byte("o"), 32))
+	ciphertext, _ := c.Encrypt([]byte(`{"Access":"1_1_1"}`))
+	store, mockDB := newMockStore(t, WithCipher(other))
+	defer store.Close()
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE access=? AND expired_at>? LIMIT 1")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "expired_at", "code", "access", "refresh", "data", "user_id"}).
+			AddRow(1, time.Now().Add(time.Hour).Unix(), "", "1_1_1", "", base64.StdEncoding.EncodeToString(ciphertext), ""))
+
+	// ACTION
+	info, err := store.GetByAccess(context.Background(), "1_1_1")
+
+	// ASSERT
+	assert.Nil(t, info)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "mysql: decrypt token:")
+}
+
+func mustAESGCMCipher(t *testing.T) Cipher {
+	c, err := NewAESGCMCipher(bytes.Repeat([]byte("k"), 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
@@ -0,0 +1,22 @@
+package mysql
+
+import (
+	jsoniter "github.com/json-iterator/go"
+)
+
+// Codec serializes the token information stored in the Data column
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// jsoniterCodec default codec, encoding/json compatible output
+type jsoniterCodec struct{}
+
+func (jsoniterCodec) Marshal(v interface{}) ([]byte, error) {
+	return jsoniter.Marshal(v)
+}
+
+func (jsoniterCodec) Unmarshal(data []byte, v interface{}) error {
+	return jsoniter.Unmarshal(data, v)
+}
@@ -0,0 +1,80 @@
+package mysql
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-oauth2/oauth2/v4/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// redactCodec encoding/json codec clearing the user id on the way in
+type redactCodec struct{}
+
+func (redactCodec) Marshal(v interface{}) ([]byte, error) {
+	if token, ok := v.(*models.Token); ok {
+		redacted := *token
+		redacted.UserID = ""
+		v = &redacted
+	}
+	return json.Marshal(v)
+}
+
+func (redactCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func TestJSONIterCodec_ShouldMatchEncodingJSON(t *testing.T) {
+	// ARRANGE
+	info := &models.Token{
+		ClientID:        "1",
+		UserID:          "1_1",
+		Access:          "1_1_1",
+		AccessCreateAt:  time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC),
+		AccessExpiresIn: time.Hour,
+	}
+
+	// ACTION
+	buf, err := jsoniterCodec{}.Marshal(info)
+
+	// ASSERT
+	assert.NoError(t, err)
+	expected, _ := json.Marshal(info)
+	assert.Equal(t, string(expected), string(buf))
+}
+
+func TestWithCodec_ShouldSerializeTokenData(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithCodec(redactCodec{}))
+	defer store.Close()
+
+	info := &models.Token{
+		UserID:          "1_1",
+		Access:          "1_1_1",
+		AccessCreateAt:  time.Now(),
+		AccessExpiresIn: time.Hour,
+	}
+	data := &captureArg{}
+	mockDB.ExpectExec(regexp.QuoteMeta("insert into `oauth2_token`")).
+		WithArgs(sqlmock.AnyArg(), "", "1_1_1", "", data, "1_1").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	// ACTION
+	err := store.Create(context.Background(), info)
+	assert.NoError(t, err)
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE access=? AND expired_at>? LIMIT 1")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "expired_at", "code", "access", "refresh", "data", "user_id"}).
+			AddRow(1, time.Now().Add(time.Hour).Unix(), "", "1_1_1", "", data.value, "1_1"))
+	got, err := store.GetByAccess(context.Background(), "1_1_1")
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, "1_1_1", got.GetAccess())
+	assert.Equal(t, "", got.GetUserID())
+	assert.NotContains(t, data.value, `"UserID":"1_1"`)
+}
@@ -0,0 +1,100 @@
+package mysql
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+// DSNParams parts of a go-sql-driver/mysql connection string, see BuildDSN
+type DSNParams struct {
+	User     string
+	Password string
+	// Host server host (default 127.0.0.1)
+	Host string
+	// Port server port (default 3306)
+	Port     int
+	Database string
+	// TLS tls parameter: "true", "skip-verify", "preferred"
+	// or the name of a config registered with mysql.RegisterTLSConfig
+	TLS string
+	// DisableParseTime leaves out parseTime=true
+	DisableParseTime bool
+	// Charset connection character set (default utf8mb4)
+	Charset string
+	// Loc location of the time values (default UTC)
+	Loc *time.Location
+	// Params extra connection parameters
+	Params map[string]string
+}
+
+// BuildDSN build the tcp connection string of the params,
+// with parseTime=true and charset=utf8mb4 unless set otherwise
+func BuildDSN(params DSNParams) string {
+	host := params.Host
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	port := params.Port
+	if port == 0 {
+		port = 3306
+	}
+	charset := params.Charset
+	if charset == "" {
+		charset = DefaultEncoding
+	}
+
+	cfg := mysqldriver.NewConfig()
+	cfg.User = params.User
+	cfg.Passwd = params.Password
+	cfg.Net = "tcp"
+	cfg.Addr = net.JoinHostPort(host, strconv.Itoa(port))
+	cfg.DBName = params.Database
+	cfg.TLSConfig = params.TLS
+	cfg.ParseTime = !params.DisableParseTime
+	if params.Loc != nil {
+		cfg.Loc = params.Loc
+	}
+	cfg.Params = map[string]string{"charset": charset}
+	for k, v := range params.Params {
+		cfg.Params[k] = v
+	}
+	return cfg.FormatDSN()
+}
+
+// ValidateDSN checks that the connection string parses as a
+// go-sql-driver/mysql DSN. NewStore runs it before opening the database,
+// since sql.Open defers the parse of a Postgres or otherwise foreign DSN
+// to the first query.
+func ValidateDSN(dsn string) error {
+	if _, err := mysqldriver.ParseDSN(dsn); err != nil {
+		return fmt.Errorf("mysql: invalid mysql dsn: %w", err)
+	}
+	return nil
+}
+
+var (
+	tlsMu    sync.Mutex
+	tlsNames = make(map[*tls.Config]string)
+)
+
+// registerTLS registers the TLS config with the driver once
+// and returns the name it is registered under
+func registerTLS(config *tls.Config) (string, error) {
+	tlsMu.Lock()
+	defer tlsMu.Unlock()
+	if name, ok := tlsNames[config]; ok {
+		return name, nil
+	}
+	name := fmt.Sprintf("oauth2-mysql-%d", len(tlsNames)+1)
+	if err := mysqldriver.RegisterTLSConfig(name, config); err != nil {
+		return "", fmt.Errorf("mysql: register tls config: %w", err)
+	}
+	tlsNames[config] = name
+	return name, nil
+}
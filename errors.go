@@ -0,0 +1,23 @@
+package mysql
+
+import "errors"
+
+// ErrNotFound no token row matched, returned by the Remove* methods
+// when WithNotFoundError is enabled
+var ErrNotFound = errors.New("mysql: token not found")
+
+// ErrTokenNotFound no live token matched, returned by the GetBy* methods
+// returning token information when WithTokenNotFoundError is enabled
+var ErrTokenNotFound = errors.New("mysql: token not found or expired")
+
+// ErrTokenExpired the token expires before it is stored,
+// usually a zero or wrong create time or expiry of the TokenInfo
+var ErrTokenExpired = errors.New("mysql: token already expired")
+
+// ErrCorruptToken the data of a token row can't be decoded, or decodes
+// to a token without code, access or refresh token, such as a row edited
+// by hand or written by an incompatible version
+var ErrCorruptToken = errors.New("mysql: corrupt token data")
+
+// ErrDataTooLong the encoded token data does not fit the Data column
+var ErrDataTooLong = errors.New("mysql: token data too long")
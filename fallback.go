@@ -0,0 +1,136 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/go-oauth2/oauth2/v4"
+)
+
+// FallbackStore best-effort token store decorator, for demos and local
+// development, sending the calls to a primary store and falling back to
+// a second store, by default in memory, when the primary fails with a
+// connection error. It is not a replication layer: tokens created during
+// an outage only live in the fallback, and are lost with it. They are
+// still found once the primary is back, lookups missing the primary also
+// query the fallback, and removals are applied to both.
+type FallbackStore struct {
+	primary  oauth2.TokenStore
+	fallback oauth2.TokenStore
+
+	mu     sync.Mutex
+	logger Logger
+}
+
+var _ oauth2.TokenStore = (*FallbackStore)(nil)
+
+// NewFallbackStore create a store falling back from primary to fallback,
+// a new MemoryStore when nil. The warnings are logged to stderr, see
+// SetLogger.
+func NewFallbackStore(primary, fallback oauth2.TokenStore) *FallbackStore {
+	if fallback == nil {
+		fallback = NewMemoryStore()
+	}
+	return &FallbackStore{
+		primary:  primary,
+		fallback: fallback,
+		logger:   NewWriterLogger(os.Stderr),
+	}
+}
+
+// SetLogger set the logger reporting the fallbacks, nil disables logging
+func (s *FallbackStore) SetLogger(logger Logger) *FallbackStore {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logger = logger
+	return s
+}
+
+// failed reports whether err is a connection error of the primary,
+// logging the fallback
+func (s *FallbackStore) failed(op string, err error) bool {
+	if !isTransient(err) {
+		return false
+	}
+	s.mu.Lock()
+	logger := s.logger
+	s.mu.Unlock()
+	if logger != nil {
+		logger.Errorf("%s: primary store unavailable, using fallback: %s", op, err)
+	}
+	return true
+}
+
+// Create create and store the new token information
+func (s *FallbackStore) Create(ctx context.Context, info oauth2.TokenInfo) error {
+	if err := s.primary.Create(ctx, info); !s.failed("create", err) {
+		return err
+	}
+	return s.fallback.Create(ctx, info)
+}
+
+// RemoveByCode delete the authorization code
+func (s *FallbackStore) RemoveByCode(ctx context.Context, code string) error {
+	return s.remove("remove by code", func(store oauth2.TokenStore) error {
+		return store.RemoveByCode(ctx, code)
+	})
+}
+
+// RemoveByAccess use the access token to delete the token information
+func (s *FallbackStore) RemoveByAccess(ctx context.Context, access string) error {
+	return s.remove("remove by access", func(store oauth2.TokenStore) error {
+		return store.RemoveByAccess(ctx, access)
+	})
+}
+
+// RemoveByRefresh use the refresh token to delete the token information
+func (s *FallbackStore) RemoveByRefresh(ctx context.Context, refresh string) error {
+	return s.remove("remove by refresh", func(store oauth2.TokenStore) error {
+		return store.RemoveByRefresh(ctx, refresh)
+	})
+}
+
+// GetByCode use the authorization code for token information data
+func (s *FallbackStore) GetByCode(ctx context.Context, code string) (oauth2.TokenInfo, error) {
+	return s.get("get by code", func(store oauth2.TokenStore) (oauth2.TokenInfo, error) {
+		return store.GetByCode(ctx, code)
+	})
+}
+
+// GetByAccess use the access token for token information data
+func (s *FallbackStore) GetByAccess(ctx context.Context, access string) (oauth2.TokenInfo, error) {
+	return s.get("get by access", func(store oauth2.TokenStore) (oauth2.TokenInfo, error) {
+		return store.GetByAccess(ctx, access)
+	})
+}
+
+// GetByRefresh use the refresh token for token information data
+func (s *FallbackStore) GetByRefresh(ctx context.Context, refresh string) (oauth2.TokenInfo, error) {
+	return s.get("get by refresh", func(store oauth2.TokenStore) (oauth2.TokenInfo, error) {
+		return store.GetByRefresh(ctx, refresh)
+	})
+}
+
+// remove removes from the primary then the fallback, which may hold a
+// token created during an outage. The error of the primary wins unless
+// it is a connection error.
+func (s *FallbackStore) remove(op string, fn func(store oauth2.TokenStore) error) error {
+	err := fn(s.primary)
+	if s.failed(op, err) {
+		return fn(s.fallback)
+	}
+	_ = fn(s.fallback)
+	return err
+}
+
+// get looks the token up in the primary, then in the fallback when the
+// primary failed with a connection error or does not hold it
+func (s *FallbackStore) get(op string, fn func(store oauth2.TokenStore) (oauth2.TokenInfo, error)) (oauth2.TokenInfo, error) {
+	info, err := fn(s.primary)
+	if info != nil || (err != nil && !errors.Is(err, ErrTokenNotFound) && !s.failed(op, err)) {
+		return info, err
+	}
+	return fn(s.fallback)
+}
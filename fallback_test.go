@@ -0,0 +1,113 @@
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-oauth2/oauth2/v4/models"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFallbackStore_ShouldFallBackOnConnectionError(t *testing.T) {
+	// ARRANGE
+	primary, mockDB := newMockStore(t)
+	defer primary.Close()
+	var logs bytes.Buffer
+	store := NewFallbackStore(primary, nil).SetLogger(NewWriterLogger(&logs))
+
+	mockDB.ExpectExec(regexp.QuoteMeta("insert into `oauth2_token`")).
+		WillReturnError(mysqldriver.ErrInvalidConn)
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE access=? AND expired_at>? LIMIT 1")).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	// ACTION
+	err := store.Create(context.Background(), &models.Token{Access: "1_1_1", AccessCreateAt: time.Now(), AccessExpiresIn: time.Hour})
+	info, getErr := store.GetByAccess(context.Background(), "1_1_1")
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.NoError(t, getErr)
+	if assert.NotNil(t, info) {
+		assert.Equal(t, "1_1_1", info.GetAccess())
+	}
+	assert.Contains(t, logs.String(), "create: primary store unavailable, using fallback: ")
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestFallbackStore_ShouldNotFallBackOnQueryError(t *testing.T) {
+	// ARRANGE
+	primary, mockDB := newMockStore(t)
+	defer primary.Close()
+	fallback := NewMemoryStore()
+	store := NewFallbackStore(primary, fallback).SetLogger(nil)
+
+	createErr := errors.New("duplicate entry")
+	mockDB.ExpectExec(regexp.QuoteMeta("insert into `oauth2_token`")).
+		WillReturnError(createErr)
+
+	// ACTION
+	err := store.Create(context.Background(), &models.Token{Access: "1_1_1", AccessCreateAt: time.Now(), AccessExpiresIn: time.Hour})
+
+	// ASSERT
+	assert.Equal(t, createErr, err)
+	assert.Empty(t, fallback.access)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestFallbackStore_ShouldRemoveFromBoth(t *testing.T) {
+	// ARRANGE
+	primary, mockDB := newMockStore(t)
+	defer primary.Close()
+	fallback := NewMemoryStore()
+	store := NewFallbackStore(primary, fallback)
+
+	err := fallback.Create(context.Background(), &models.Token{Code: "11_11_11", CodeCreateAt: time.Now(), CodeExpiresIn: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE `oauth2_token` SET code='' WHERE code=?")).
+		WithArgs("11_11_11").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// ACTION
+	err = store.RemoveByCode(context.Background(), "11_11_11")
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Empty(t, fallback.code)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestMemoryStore_ShouldDropExpiredTokens(t *testing.T) {
+	// ARRANGE
+	store := NewMemoryStore()
+	now := time.Now()
+	store.now = func() time.Time { return now.Add(2 * time.Hour) }
+
+	err := store.Create(context.Background(), &models.Token{
+		Access: "1_1_1", AccessCreateAt: now, AccessExpiresIn: time.Hour,
+		Refresh: "2_2_2", RefreshCreateAt: now, RefreshExpiresIn: 24 * time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ACTION
+	access, accessErr := store.GetByAccess(context.Background(), "1_1_1")
+	refresh, refreshErr := store.GetByRefresh(context.Background(), "2_2_2")
+
+	// ASSERT
+	assert.NoError(t, accessErr)
+	assert.NoError(t, refreshErr)
+	assert.Nil(t, access)
+	assert.Empty(t, store.access)
+	if assert.NotNil(t, refresh) {
+		assert.Equal(t, "2_2_2", refresh.GetRefresh())
+	}
+}
@@ -0,0 +1,58 @@
+package mysql
+
+import (
+	"fmt"
+	"io"
+)
+
+// Logger logger interface used by the store to report errors,
+// it can be implemented on top of structured loggers such as zap or slog
+type Logger interface {
+	Errorf(format string, args ...interface{})
+}
+
+// InfoLogger optional interface of a Logger also reporting the progress
+// of long running maintenance such as ConvertEngine
+type InfoLogger interface {
+	Infof(format string, args ...interface{})
+}
+
+// DebugLogger optional interface of a Logger also reporting routine
+// events, such as gc cycles that deleted nothing
+type DebugLogger interface {
+	Debugf(format string, args ...interface{})
+}
+
+// LogLevel minimum level of the lines the store logs, see WithLogLevel
+type LogLevel int
+
+// Log levels from the most to the least verbose
+const (
+	LogLevelDebug LogLevel = iota + 1
+	LogLevelInfo
+	LogLevelError
+)
+
+// NewWriterLogger create a logger writing prefixed lines to the writer
+func NewWriterLogger(w io.Writer) Logger {
+	return &writerLogger{w: w}
+}
+
+type writerLogger struct {
+	w io.Writer
+}
+
+func (l *writerLogger) Errorf(format string, args ...interface{}) {
+	buf := fmt.Sprintf("[OAUTH2-MYSQL-ERROR]: "+format, args...)
+	_, _ = l.w.Write([]byte(buf))
+}
+
+func (l *writerLogger) Infof(format string, args ...interface{}) {
+	buf := fmt.Sprintf("[OAUTH2-MYSQL-INFO]: "+format, args...)
+	_, _ = l.w.Write([]byte(buf))
+}
+
+func (l *writerLogger) Debugf(format string, args ...interface{}) {
+	buf := fmt.Sprintf("[OAUTH2-MYSQL-DEBUG]: "+format, args...)
+	_, _ = l.w.Write([]byte(buf))
+}
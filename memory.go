@@ -0,0 +1,121 @@
+package mysql
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+)
+
+// MemoryStore token store keeping the tokens in memory, for local
+// development and tests without a database, and the default fallback of
+// FallbackStore. Expired tokens are dropped when looked up, there is no
+// gc: it is not meant for long running servers.
+type MemoryStore struct {
+	mu      sync.Mutex
+	code    map[string]oauth2.TokenInfo
+	access  map[string]oauth2.TokenInfo
+	refresh map[string]oauth2.TokenInfo
+	now     func() time.Time
+}
+
+var _ oauth2.TokenStore = (*MemoryStore)(nil)
+
+// NewMemoryStore create an empty memory token store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		code:    make(map[string]oauth2.TokenInfo),
+		access:  make(map[string]oauth2.TokenInfo),
+		refresh: make(map[string]oauth2.TokenInfo),
+		now:     time.Now,
+	}
+}
+
+// Create create and store the new token information
+func (s *MemoryStore) Create(ctx context.Context, info oauth2.TokenInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if code := info.GetCode(); code != "" {
+		s.code[code] = info
+		return nil
+	}
+	if access := info.GetAccess(); access != "" {
+		s.access[access] = info
+	}
+	if refresh := info.GetRefresh(); refresh != "" {
+		s.refresh[refresh] = info
+	}
+	return nil
+}
+
+// RemoveByCode delete the authorization code
+func (s *MemoryStore) RemoveByCode(ctx context.Context, code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.code, code)
+	return nil
+}
+
+// RemoveByAccess use the access token to delete the token information
+func (s *MemoryStore) RemoveByAccess(ctx context.Context, access string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.access, access)
+	return nil
+}
+
+// RemoveByRefresh use the refresh token to delete the token information
+func (s *MemoryStore) RemoveByRefresh(ctx context.Context, refresh string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.refresh, refresh)
+	return nil
+}
+
+// GetByCode use the authorization code for token information data
+func (s *MemoryStore) GetByCode(ctx context.Context, code string) (oauth2.TokenInfo, error) {
+	return s.get(s.code, code, func(info oauth2.TokenInfo) time.Time {
+		return expiresAt(info.GetCodeCreateAt(), info.GetCodeExpiresIn())
+	}), nil
+}
+
+// GetByAccess use the access token for token information data
+func (s *MemoryStore) GetByAccess(ctx context.Context, access string) (oauth2.TokenInfo, error) {
+	return s.get(s.access, access, func(info oauth2.TokenInfo) time.Time {
+		return expiresAt(info.GetAccessCreateAt(), info.GetAccessExpiresIn())
+	}), nil
+}
+
+// GetByRefresh use the refresh token for token information data
+func (s *MemoryStore) GetByRefresh(ctx context.Context, refresh string) (oauth2.TokenInfo, error) {
+	return s.get(s.refresh, refresh, func(info oauth2.TokenInfo) time.Time {
+		return expiresAt(info.GetRefreshCreateAt(), info.GetRefreshExpiresIn())
+	}), nil
+}
+
+// expiresAt returns the expiry of a token, zero when it never expires
+func expiresAt(createAt time.Time, expiresIn time.Duration) time.Time {
+	if expiresIn <= 0 {
+		return time.Time{}
+	}
+	return createAt.Add(expiresIn)
+}
+
+// get returns the token of the key, deleting it once expired
+func (s *MemoryStore) get(tokens map[string]oauth2.TokenInfo, key string, expiry func(info oauth2.TokenInfo) time.Time) oauth2.TokenInfo {
+	if key == "" {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info, ok := tokens[key]
+	if !ok {
+		return nil
+	}
+	if at := expiry(info); !at.IsZero() && !s.now().Before(at) {
+		delete(tokens, key)
+		return nil
+	}
+	return info
+}
@@ -0,0 +1,50 @@
+package mysql
+
+import "time"
+
+// Metrics receives observations about the store's garbage collection,
+// it can be wired to Prometheus counters and histograms
+type Metrics interface {
+	// ObserveGC is called after each gc cycle with the number of rows
+	// deleted, the time the cycle took and the error it ended with
+	ObserveGC(deleted int64, duration time.Duration, err error)
+}
+
+// QueryMetrics can be implemented by a Metrics hook to also observe
+// the store operations
+type QueryMetrics interface {
+	// ObserveQuery is called after every Create, Update, Get*, Remove*
+	// and PurgeExpired call with the method name, its duration and error
+	ObserveQuery(operation string, duration time.Duration, err error)
+}
+
+// observeGC reports a gc cycle to the metrics hook,
+// a panicking hook is logged instead of killing the gc goroutine
+func (s *Store) observeGC(deleted int64, duration time.Duration, err error) {
+	if s.metrics == nil {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			s.errorf("metrics hook panic: %v", r)
+		}
+	}()
+	s.metrics.ObserveGC(deleted, duration, err)
+}
+
+// observeQuery reports a store operation to the metrics hook
+// when it implements QueryMetrics
+func (s *Store) observeQuery(operation string, duration time.Duration, err error) {
+	qm, ok := s.metrics.(QueryMetrics)
+	if !ok {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			s.errorf("metrics hook panic: %v", r)
+		}
+	}()
+	qm.ObserveQuery(operation, duration, err)
+}
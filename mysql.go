@@ -1,8 +1,15 @@
 package mysql
 
 import (
+	"context"
+	"crypto/tls"
 	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
 	"os"
+	"regexp"
+	"strings"
 	"time"
 
 	"gopkg.in/gorp.v2"
@@ -17,8 +24,88 @@ type StoreItem struct {
 	Refresh   string `db:"refresh,size:255"`
 	Data      string `db:"data,size:2048"`
 	UserID    string `db:"user_id,size:16"`
+	// TenantID is only stored when the store is scoped with WithTenant
+	TenantID string `db:"tenant_id,size:64"`
+	// ClientID is only stored with WithClientIDColumn
+	ClientID string `db:"client_id,size:128"`
+	// AccessExpiredAt expiry of the access token, only stored with
+	// WithSplitExpiry. ExpiredAt stays the expiry of the whole row,
+	// the refresh token one when there is a refresh token.
+	AccessExpiredAt int64 `db:"access_expired_at"`
+	// DeletedAt unix time the row was revoked, 0 while it is live, only
+	// stored with WithSoftDelete
+	DeletedAt int64 `db:"deleted_at"`
+	// UUID primary key of the row when the store uses WithUUIDKeys,
+	// ID is 0 then
+	UUID string `db:"-"`
 }
 
+// ColumnSizes maximum sizes of the token table columns, zero keeps the
+// default. gorp maps sizes up to 255 to VARCHAR and larger ones to TEXT,
+// token columns stored as TEXT are indexed on their first 255 characters.
+// Sizes only apply when the table is created, Migrate widens the columns
+// of an existing table.
+type ColumnSizes struct {
+	Code    int
+	Access  int
+	Refresh int
+	Data    int
+}
+
+// DefaultColumnSizes returns the column sizes declared on StoreItem
+func DefaultColumnSizes() ColumnSizes {
+	return ColumnSizes{Code: 255, Access: 255, Refresh: 255, Data: 2048}
+}
+
+// Index secondary index created on the token table
+type Index struct {
+	Name    string
+	Columns []string
+	// Unique creates a unique index, the empty value of removed tokens
+	// is exempt on the code, access and refresh columns (MySQL 8.0.13+)
+	Unique bool
+}
+
+// DefaultIndexes returns the indexes created on the token table by default.
+// The composite (token, expired_at) indexes also serve plain token lookups.
+// The store creates the missing indexes every time it starts, an index
+// already existing under the same name is kept as is. A table created by
+// an older version therefore has idx_access_expired and
+// idx_refresh_expired built on the first start, which delays the start on
+// a large table. Create them beforehand, then drop the single column
+// idx_access and idx_refresh indexes they replace, nothing uses those
+// anymore. On a table other than oauth2_token index names are prefixed
+// with the table name, such as custom_table_idx_code, as some databases
+// require unique index names. The indexes an older version created there
+// under the plain names keep them.
+func DefaultIndexes() []Index {
+	return []Index{
+		{Name: "idx_code", Columns: []string{"code"}},
+		{Name: "idx_access_expired", Columns: []string{"access", "expired_at"}},
+		{Name: "idx_refresh_expired", Columns: []string{"refresh", "expired_at"}},
+		{Name: "idx_expired_at", Columns: []string{"expired_at"}},
+		{Name: "idx_user_id", Columns: []string{"user_id"}},
+	}
+}
+
+const (
+	// DefaultTableName name of the token table when the table name
+	// passed to the constructors is empty, or WithTableName is not used
+	DefaultTableName = "oauth2_token"
+	// DefaultEngine default storage engine of the token table
+	DefaultEngine = "InnoDB"
+	// DefaultEncoding default character set of the token table
+	DefaultEncoding = "utf8mb4"
+	// DefaultGCBatchSize default number of rows deleted per gc statement
+	DefaultGCBatchSize = 1000
+	// DefaultInsertBatchSize default number of rows inserted per CreateBatch statement
+	DefaultInsertBatchSize = 500
+	// DefaultRetryBackoff default wait before retrying a broken connection
+	DefaultRetryBackoff = time.Millisecond * 100
+)
+
+var identifierRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
 // NewConfig create mysql configuration instance
 func NewConfig(dsn string) *Config {
 	return &Config{
@@ -26,15 +113,178 @@ func NewConfig(dsn string) *Config {
 		MaxLifetime:  time.Hour * 2,
 		MaxOpenConns: 50,
 		MaxIdleConns: 25,
+		Engine:       DefaultEngine,
+		Encoding:     DefaultEncoding,
+		GCBatchSize:  DefaultGCBatchSize,
 	}
 }
 
+// NewConfigWithParams create mysql configuration instance
+// from the connection string built by BuildDSN
+func NewConfigWithParams(params DSNParams) *Config {
+	return NewConfig(BuildDSN(params))
+}
+
 // Config mysql configuration
 type Config struct {
 	DSN          string
 	MaxLifetime  time.Duration
 	MaxOpenConns int
+	// MaxIdleConns is capped to MaxOpenConns, NewStore logs the
+	// settings it had to change
 	MaxIdleConns int
+	// MaxIdleTime close connections idle for longer, before a load
+	// balancer drops them silently (default 0, unlimited). A connection
+	// is closed once either MaxIdleTime or MaxLifetime is reached.
+	MaxIdleTime time.Duration
+	// Engine storage engine used when creating the table (default InnoDB)
+	Engine string
+	// Encoding character set used when creating the table (default utf8mb4),
+	// utf8mb4 is required to store 4-byte characters such as emoji.
+	// The connection charset in the DSN should match it.
+	Encoding string
+	// RowFormat and KeyBlockSize InnoDB table options used when creating
+	// the table, see WithRowFormat and WithKeyBlockSize
+	RowFormat    string
+	KeyBlockSize int
+	// TableConstraints table constraints used when creating the table,
+	// see WithTableConstraints
+	TableConstraints []string
+	// GCJitter maximum random delay added to every gc interval (default 0)
+	GCJitter time.Duration
+	// GCDryRun only count and log the rows the gc would delete,
+	// see WithGCDryRun
+	GCDryRun bool
+	// GCMissingTable what the gc does when the table does not exist,
+	// see WithGCMissingTable
+	GCMissingTable MissingTableAction
+	// GCBatchSize maximum number of rows deleted per gc statement (default 1000)
+	GCBatchSize int
+	// GCUnordered drops the ORDER BY of the gc batches, see WithGCUnordered
+	GCUnordered bool
+	// GCResetAutoIncrement reset the autoincrement of the MyISAM table
+	// the gc empties, see WithGCResetAutoIncrement
+	GCResetAutoIncrement bool
+	// MaxRows cap of the token table rows, the gc evicting those expiring
+	// first beyond it, see WithMaxRows (default 0, no cap)
+	MaxRows int
+	// SkipCorruptRows skip the rows ListTokens can't decode,
+	// see WithSkipCorruptRows
+	SkipCorruptRows bool
+	// InsertBatchSize maximum number of rows inserted per CreateBatch
+	// statement (default 500)
+	InsertBatchSize int
+	// HardDelete delete the whole row in the Remove* methods
+	// instead of clearing the token column
+	HardDelete bool
+	// SoftDeleteRetention revoke the removed rows with a deleted_at
+	// column and delete them after this retention, see WithSoftDelete
+	// (default 0, rows are removed right away)
+	SoftDeleteRetention time.Duration
+	// SkipTableCreation do not create the token table and its indexes,
+	// see WithSkipTableCreation
+	SkipTableCreation bool
+	// LogLevel minimum level of the logged lines, see WithLogLevel
+	LogLevel LogLevel
+	// QueryLogger hook called after every statement, with its string
+	// arguments redacted unless UnsafeQueryArgs is set,
+	// see WithQueryLogger
+	QueryLogger     QueryLogger
+	UnsafeQueryArgs bool
+	// AuditHooks callbacks reporting the stored and removed tokens,
+	// see WithAuditHooks
+	AuditHooks AuditHooks
+	// StatementCache prepare the lookups and removals by token once,
+	// see WithStatementCache
+	StatementCache bool
+	// Redactor masks the tokens in the query logger arguments and the
+	// errors (default MaskRedactor), see WithRedactor
+	Redactor Redactor
+	// ClientIDColumn store the client id in an indexed column,
+	// see WithClientIDColumn
+	ClientIDColumn bool
+	// SplitExpiry track the access token expiry apart from the row one,
+	// see WithSplitExpiry
+	SplitExpiry bool
+	// UUIDKeys key the token table by UUIDs instead of an autoincrement
+	// id, see WithUUIDKeys
+	UUIDKeys bool
+	// ReadExpired return expired tokens from the Get* lookups until
+	// the gc deletes them, see WithReadExpired
+	ReadExpired bool
+	// TenantID scope the store to a tenant sharing the table, see WithTenant
+	TenantID string
+	// NotFoundError return ErrNotFound from the Remove* methods
+	// when no row matched the token
+	NotFoundError bool
+	// TokenNotFoundError return ErrTokenNotFound from the GetBy* methods
+	// when no token matched, see WithTokenNotFoundError
+	TokenNotFoundError bool
+	// Indexes indexes created on the token table (default DefaultIndexes)
+	Indexes []Index
+	// UniqueTokens also create unique indexes on code, access and refresh,
+	// see WithUniqueTokens
+	UniqueTokens bool
+	// NullTokens clear removed tokens to NULL instead of the empty string,
+	// see WithNullTokens
+	NullTokens bool
+	// CodeSize, AccessSize, RefreshSize and DataSize override the
+	// column sizes of the token table, see ColumnSizes
+	CodeSize    int
+	AccessSize  int
+	RefreshSize int
+	DataSize    int
+	// DataColumnType SQL type of the data column, DataTypeText,
+	// DataTypeJSON or DataTypeVarchar, see WithDataColumnType
+	DataColumnType string
+	// TypeConverter gorp converter of the column values,
+	// see WithTypeConverter
+	TypeConverter gorp.TypeConverter
+	// Collation, ParseTime and Loc driver settings added to the DSN
+	// unless it already sets them. The token table stores unix seconds
+	// and does not need them, they keep the connections consistent for
+	// other tables of a shared database. NewStoreWithDB and the other
+	// constructors taking a *sql.DB leave the caller's DSN alone.
+	Collation string
+	ParseTime bool
+	Loc       *time.Location
+	// ReadTimeout and WriteTimeout driver I/O timeouts added to the DSN
+	// as readTimeout and writeTimeout when non zero, unless it already
+	// sets them. They bound every network read and write, unlike
+	// QueryTimeout, so a query on a half-open connection fails instead
+	// of hanging.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// QueryTimeout maximum duration of a store operation, see
+	// WithQueryTimeout (default 0, no timeout)
+	QueryTimeout time.Duration
+	// MaxRetries how many times Create and the Get* lookups are retried
+	// after a broken connection (default 0, no retries)
+	MaxRetries int
+	// RetryBackoff wait before the first retry, doubled for every
+	// following one (default 100ms)
+	RetryBackoff time.Duration
+	// StartupPingAttempts ping the database up to this many times before
+	// the store starts, waiting StartupPingBackoff (default 100ms) after
+	// the first failure, see WithStartupPing (default 0, no ping)
+	StartupPingAttempts int
+	StartupPingBackoff  time.Duration
+	// TLS client TLS configuration, registered with the driver under a
+	// generated name and referenced by a tls parameter in the DSN unless
+	// it already sets one. NewStoreWithDB and the other constructors
+	// taking a *sql.DB leave TLS to the caller.
+	TLS *tls.Config
+}
+
+func (c *Config) dialect() gorp.MySQLDialect {
+	dialect := gorp.MySQLDialect{Engine: c.Engine, Encoding: c.Encoding}
+	if dialect.Engine == "" {
+		dialect.Engine = DefaultEngine
+	}
+	if dialect.Encoding == "" {
+		dialect.Encoding = DefaultEncoding
+	}
+	return dialect
 }
 
 // NewDefaultStore create mysql store instance
@@ -44,48 +294,326 @@ func NewDefaultStore(config *Config) *Store {
 
 // NewStore create mysql store instance,
 // config mysql configuration,
-// tableName table name (default oauth2_token),
-// GC time interval (in seconds, default 600)
+// tableName table name (empty for DefaultTableName),
+// GC time interval (in seconds, default 600, negative disables GC)
 func NewStore(config *Config, tableName string, gcInterval int) *Store {
-	db, err := sql.Open("mysql", config.DSN)
+	store, err := NewStoreE(config, tableName, gcInterval)
+	if err != nil {
+		panic(err)
+	}
+	return store
+}
+
+// NewStoreE create mysql store instance like NewStore,
+// but returns the error instead of panicking
+func NewStoreE(config *Config, tableName string, gcInterval int) (*Store, error) {
+	db, err := openDB(config)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := newStore(db,
+		WithSQLDialect(config.dialect()),
+		WithTableName(tableName),
+		WithGCTimeInterval(gcInterval),
+		WithGCJitter(config.GCJitter),
+		WithGCDryRun(config.GCDryRun),
+		WithGCMissingTable(config.GCMissingTable),
+		WithGCBatchSize(config.GCBatchSize),
+		WithGCUnordered(config.GCUnordered),
+		WithGCResetAutoIncrement(config.GCResetAutoIncrement),
+		WithMaxRows(config.MaxRows),
+		WithSkipCorruptRows(config.SkipCorruptRows),
+		WithInsertBatchSize(config.InsertBatchSize),
+		WithHardDelete(config.HardDelete),
+		WithSoftDelete(config.SoftDeleteRetention),
+		WithReadExpired(config.ReadExpired),
+		WithUUIDKeys(config.UUIDKeys),
+		WithLogLevel(config.LogLevel),
+		WithQueryLogger(config.QueryLogger),
+		WithUnsafeQueryArgs(config.UnsafeQueryArgs),
+		WithRedactor(config.Redactor),
+		WithAuditHooks(config.AuditHooks),
+		WithStatementCache(config.StatementCache),
+		WithClientIDColumn(config.ClientIDColumn),
+		WithSplitExpiry(config.SplitExpiry),
+		WithSkipTableCreation(config.SkipTableCreation),
+		WithNotFoundError(config.NotFoundError),
+		WithTokenNotFoundError(config.TokenNotFoundError),
+		WithTenant(config.TenantID),
+		WithIndexes(config.Indexes...),
+		WithUniqueTokens(config.UniqueTokens),
+		WithNullTokens(config.NullTokens),
+		WithColumnSizes(ColumnSizes{
+			Code:    config.CodeSize,
+			Access:  config.AccessSize,
+			Refresh: config.RefreshSize,
+			Data:    config.DataSize,
+		}),
+		WithDataColumnType(config.DataColumnType),
+		WithRowFormat(config.RowFormat),
+		WithKeyBlockSize(config.KeyBlockSize),
+		WithTableConstraints(config.TableConstraints...),
+		WithTypeConverter(config.TypeConverter),
+		WithQueryTimeout(config.QueryTimeout),
+		WithRetry(config.MaxRetries, config.RetryBackoff),
+		WithStartupPing(config.StartupPingAttempts, config.StartupPingBackoff),
+	)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	_, _, warnings := config.poolSizes()
+	for _, warning := range warnings {
+		store.errorf("mysql: config: %s", warning)
+	}
+	return store, nil
+}
+
+// NewStoreWithOptions create mysql store instance from the dsn,
+// using the default connection pool settings of NewConfig
+// and configured by the options
+func NewStoreWithOptions(dsn string, opts ...Option) *Store {
+	store, err := NewStoreWithOptionsE(dsn, opts...)
 	if err != nil {
 		panic(err)
 	}
+	return store
+}
+
+// NewStoreWithOptionsE create mysql store instance like NewStoreWithOptions,
+// but returns the error instead of panicking
+func NewStoreWithOptionsE(dsn string, opts ...Option) (*Store, error) {
+	config := NewConfig(dsn)
+	db, err := openDB(config)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := newStore(db, append([]Option{WithSQLDialect(config.dialect())}, opts...)...)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// driverDSN returns the DSN with the driver settings of the config
+// appended when it does not set them already, tlsName is the name
+// the TLS config is registered under
+func (c *Config) driverDSN(tlsName string) string {
+	dsn := c.DSN
+	// the parameters follow the first ? after the database name
+	sep, query := "?", ""
+	name := dsn[strings.LastIndex(dsn, "/")+1:]
+	if i := strings.Index(name, "?"); i >= 0 {
+		query = name[i+1:]
+		sep = "&"
+		if query == "" || strings.HasSuffix(query, "&") {
+			sep = ""
+		}
+	}
+	present := make(map[string]bool)
+	for _, param := range strings.Split(query, "&") {
+		if name := strings.SplitN(param, "=", 2)[0]; name != "" {
+			present[name] = true
+		}
+	}
 
-	db.SetMaxOpenConns(config.MaxOpenConns)
-	db.SetMaxIdleConns(config.MaxIdleConns)
-	db.SetConnMaxLifetime(config.MaxLifetime)
+	var params []string
+	if c.Collation != "" && !present["collation"] {
+		params = append(params, "collation="+url.QueryEscape(c.Collation))
+	}
+	if c.ParseTime && !present["parseTime"] {
+		params = append(params, "parseTime=true")
+	}
+	if c.Loc != nil && !present["loc"] {
+		params = append(params, "loc="+url.QueryEscape(c.Loc.String()))
+	}
+	if c.ReadTimeout > 0 && !present["readTimeout"] {
+		params = append(params, "readTimeout="+c.ReadTimeout.String())
+	}
+	if c.WriteTimeout > 0 && !present["writeTimeout"] {
+		params = append(params, "writeTimeout="+c.WriteTimeout.String())
+	}
+	if tlsName != "" && !present["tls"] {
+		params = append(params, "tls="+url.QueryEscape(tlsName))
+	}
+	if len(params) == 0 {
+		return dsn
+	}
+	return dsn + sep + strings.Join(params, "&")
+}
 
-	return NewStoreWithDB(db, tableName, gcInterval)
+// poolSizes returns the connection pool sizes applied to the db, with a
+// warning for every setting database/sql would silently change
+func (c *Config) poolSizes() (maxOpen, maxIdle int, warnings []string) {
+	maxOpen, maxIdle = c.MaxOpenConns, c.MaxIdleConns
+	if maxOpen < 0 {
+		warnings = append(warnings, fmt.Sprintf("MaxOpenConns %d is negative, using 0 (unlimited)", maxOpen))
+		maxOpen = 0
+	}
+	if maxIdle < 0 {
+		warnings = append(warnings, fmt.Sprintf("MaxIdleConns %d is negative, using 0 (no idle connections)", maxIdle))
+		maxIdle = 0
+	}
+	if maxOpen > 0 && maxIdle > maxOpen {
+		warnings = append(warnings, fmt.Sprintf("MaxIdleConns %d exceeds MaxOpenConns %d, using %d", maxIdle, maxOpen, maxOpen))
+		maxIdle = maxOpen
+	}
+	return maxOpen, maxIdle, warnings
+}
+
+func openDB(config *Config) (*sql.DB, error) {
+	if err := ValidateDSN(config.DSN); err != nil {
+		return nil, err
+	}
+
+	var tlsName string
+	if config.TLS != nil {
+		name, err := registerTLS(config.TLS)
+		if err != nil {
+			return nil, err
+		}
+		tlsName = name
+	}
+
+	db, err := sql.Open("mysql", config.driverDSN(tlsName))
+	if err != nil {
+		return nil, fmt.Errorf("mysql: open dsn: %w", err)
+	}
+
+	config.setPool(db)
+	return db, nil
+}
+
+// setPool applies the connection pool settings of the config to the db
+func (c *Config) setPool(db *sql.DB) {
+	maxOpen, maxIdle, _ := c.poolSizes()
+	db.SetMaxOpenConns(maxOpen)
+	db.SetMaxIdleConns(maxIdle)
+	db.SetConnMaxLifetime(c.MaxLifetime)
+	db.SetConnMaxIdleTime(c.MaxIdleTime)
 }
 
 // NewStoreWithDB create mysql store instance,
 // db sql.DB,
-// tableName table name (default oauth2_token),
-// GC time interval (in seconds, default 600)
+// tableName table name (empty for DefaultTableName),
+// GC time interval (in seconds, default 600, negative disables GC)
 func NewStoreWithDB(db *sql.DB, tableName string, gcInterval int) *Store {
+	store, err := NewStoreWithDBE(db, tableName, gcInterval)
+	if err != nil {
+		panic(err)
+	}
+	return store
+}
+
+// NewStoreWithDBE create mysql store instance like NewStoreWithDB,
+// but returns the error instead of panicking
+func NewStoreWithDBE(db *sql.DB, tableName string, gcInterval int) (*Store, error) {
 	// Init store with options
-	store := NewStoreWithOpts(db,
-		WithSQLDialect(gorp.MySQLDialect{Encoding: "UTF8", Engine: "MyISAM"}),
+	store, err := newStore(db,
+		WithSQLDialect(gorp.MySQLDialect{Encoding: DefaultEncoding, Engine: DefaultEngine}),
 		WithTableName(tableName),
 		WithGCTimeInterval(gcInterval),
 	)
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
 
-	go store.gc()
+// NewStoreWithDBs create mysql store instance with read/write splitting,
+// writeDB handles Create, Remove* and GC,
+// readDB (optional, default writeDB) handles the Get* and Count lookups,
+// tableName table name (empty for DefaultTableName),
+// GC time interval (in seconds, default 600, negative disables GC)
+func NewStoreWithDBs(writeDB, readDB *sql.DB, tableName string, gcInterval int) *Store {
+	store, err := NewStoreWithDBsE(writeDB, readDB, tableName, gcInterval)
+	if err != nil {
+		panic(err)
+	}
 	return store
 }
 
+// NewStoreWithDBsE create mysql store instance like NewStoreWithDBs,
+// but returns the error instead of panicking
+func NewStoreWithDBsE(writeDB, readDB *sql.DB, tableName string, gcInterval int) (*Store, error) {
+	return newStore(writeDB,
+		WithSQLDialect(gorp.MySQLDialect{Encoding: DefaultEncoding, Engine: DefaultEngine}),
+		WithTableName(tableName),
+		WithGCTimeInterval(gcInterval),
+		WithReadDB(readDB),
+	)
+}
+
+// NewStoreWithDbMap create mysql store instance sharing the gorp map,
+// and so its connection pool, dialect and type converter, of the
+// application. The token table is registered on the map, a map already
+// holding it under the same name is reused. Closing the store leaves the
+// database open.
+// tableName table name (empty for DefaultTableName),
+// GC time interval (in seconds, default 600, negative disables GC)
+func NewStoreWithDbMap(dbmap *gorp.DbMap, tableName string, gcInterval int) *Store {
+	store, err := NewStoreWithDbMapE(dbmap, tableName, gcInterval)
+	if err != nil {
+		panic(err)
+	}
+	return store
+}
+
+// NewStoreWithDbMapE create mysql store instance like NewStoreWithDbMap,
+// but returns the error instead of panicking
+func NewStoreWithDbMapE(dbmap *gorp.DbMap, tableName string, gcInterval int) (*Store, error) {
+	if dbmap == nil || dbmap.Db == nil || dbmap.Dialect == nil {
+		return nil, errors.New("mysql: the DbMap needs a database and a dialect")
+	}
+	return newStore(dbmap.Db,
+		optionFunc(func(store *Store) {
+			store.db = dbmap
+			store.sharedDB = true
+		}),
+		WithTableName(tableName),
+		WithGCTimeInterval(gcInterval),
+	)
+}
+
 // NewStoreWithOpts create mysql store instance with apply custom input,
 // db sql.DB,
-// tableName table name (default oauth2_token),
-// GC time interval (in seconds, default 600)
+// tableName table name (empty for DefaultTableName),
+// GC time interval (in seconds, default 600, negative disables GC)
 func NewStoreWithOpts(db *sql.DB, opts ...Option) *Store {
+	store, err := NewStoreWithOptsE(db, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return store
+}
+
+// NewStoreWithOptsE create mysql store instance like NewStoreWithOpts,
+// but returns the error instead of panicking
+func NewStoreWithOptsE(db *sql.DB, opts ...Option) (*Store, error) {
+	return newStore(db, opts...)
+}
+
+func newStore(db *sql.DB, opts ...Option) (*Store, error) {
 	// Init store with default value
 	store := &Store{
-		db:        &gorp.DbMap{Db: db, Dialect: gorp.MySQLDialect{Encoding: "UTF8", Engine: "MyISAM"}},
-		tableName: "oauth2_token",
-		stdout:    os.Stderr,
-		ticker:    time.NewTicker(time.Second * time.Duration(600)),
+		db:              &gorp.DbMap{Db: db, Dialect: gorp.MySQLDialect{Encoding: DefaultEncoding, Engine: DefaultEngine}},
+		tableName:       DefaultTableName,
+		logger:          NewWriterLogger(os.Stderr),
+		codec:           jsoniterCodec{},
+		redactor:        MaskRedactor,
+		now:             time.Now,
+		done:            make(chan struct{}),
+		gcInterval:      time.Second * 600,
+		gcBatchSize:     DefaultGCBatchSize,
+		insertBatchSize: DefaultInsertBatchSize,
+		indexes:         DefaultIndexes(),
+		sizes:           DefaultColumnSizes(),
+		retryBackoff:    DefaultRetryBackoff,
+		pingBackoff:     DefaultRetryBackoff,
+		logLevel:        LogLevelInfo,
 	}
 
 	// Apply with optional function
@@ -93,20 +621,58 @@ func NewStoreWithOpts(db *sql.DB, opts ...Option) *Store {
 		opt.apply(store)
 	}
 
-	table := store.db.AddTableWithName(StoreItem{}, store.tableName)
-	table.AddIndex("idx_code", "Btree", []string{"code"})
-	table.AddIndex("idx_access", "Btree", []string{"access"})
-	table.AddIndex("idx_refresh", "Btree", []string{"refresh"})
-	table.AddIndex("idx_expired_at", "Btree", []string{"expired_at"})
-	table.AddIndex("idx_user_id", "Btree", []string{"user_id"})
+	switch {
+	case store.sharedDB && (store.nullTokens || store.typeConverter != nil):
+		return nil, errors.New("mysql: the type converter of a shared DbMap can't be replaced")
+	case store.nullTokens && store.typeConverter != nil:
+		return nil, errors.New("mysql: WithNullTokens and WithTypeConverter can't be combined")
+	case store.nullTokens:
+		store.db.TypeConverter = nullStringConverter{}
+	case store.typeConverter != nil:
+		store.db.TypeConverter = store.typeConverter
+	}
+	if store.readDB == nil {
+		store.readDB = store.db
+	} else {
+		store.readDB.Dialect = store.db.Dialect
+		store.readDB.TypeConverter = store.db.TypeConverter
+	}
 
-	err := store.db.CreateTablesIfNotExists()
-	if err != nil {
-		panic(err)
+	if !identifierRegexp.MatchString(store.tableName) {
+		return nil, fmt.Errorf("mysql: invalid table name %q", store.tableName)
 	}
 
-	_ = store.db.CreateIndex()
+	store.buildQueries()
+	if err := store.checkDataType(); err != nil {
+		return nil, err
+	}
+	if err := store.checkTableOptions(); err != nil {
+		return nil, err
+	}
+	if err := store.checkAutoIncrement(); err != nil {
+		return nil, err
+	}
+	if err := store.checkSoftDelete(); err != nil {
+		return nil, err
+	}
+	if store.pingAttempts > 0 {
+		if err := store.startupPing(); err != nil {
+			return nil, err
+		}
+	}
+	if err := store.createSchema(); err != nil {
+		return nil, err
+	}
+	if store.cacheStmts {
+		store.stmts = map[*gorp.DbMap]*stmtCache{store.db: store.newStmtCache(store.db.Db)}
+		if store.readDB != store.db {
+			store.stmts[store.readDB] = store.newStmtCache(store.readDB.Db)
+		}
+	}
 
-	go store.gc()
-	return store
+	store.gcCtx, store.gcCancel = context.WithCancel(context.Background())
+	if store.gcInterval > 0 {
+		store.startGC()
+	}
+	return store, nil
 }
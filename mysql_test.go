@@ -1,26 +1,61 @@
 package mysql
 
 import (
+	"bytes"
 	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
 	"regexp"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-oauth2/oauth2/v4"
 	"github.com/go-oauth2/oauth2/v4/models"
-	_ "github.com/go-sql-driver/mysql"
+	mysqldriver "github.com/go-sql-driver/mysql"
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/gorp.v2"
 )
 
 const (
-	dsn = "root:@tcp(127.0.0.1:3306)/myapp_test?charset=utf8"
+	dsn = "root:@tcp(127.0.0.1:3306)/myapp_test?charset=utf8mb4"
 )
 
+// newMockStore creates a store backed by sqlmock, with the table
+// creation statement already expected and the background gc disabled.
+func newMockStore(t *testing.T, opts ...Option) (*Store, sqlmock.Sqlmock) {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDefaultIndexes(mockDB)
+	return NewStoreWithOpts(db, append([]Option{WithGCTimeInterval(-1)}, opts...)...), mockDB
+}
+
+// expectDefaultIndexes expects the creation of the default indexes
+func expectDefaultIndexes(mockDB sqlmock.Sqlmock) {
+	for _, index := range DefaultIndexes() {
+		mockDB.ExpectExec(regexp.QuoteMeta("create index " + index.Name + " on")).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+}
+
 func TestTokenStore(t *testing.T) {
+	store, err := NewStoreE(NewConfig(dsn), "", 0)
+	if err != nil {
+		t.Skipf("mysql is not available: %v", err)
+	}
+
 	Convey("Test mysql token store", t, func() {
-		store := NewDefaultStore(NewConfig(dsn))
 		defer store.clean()
 
 		ctx := context.Background()
@@ -50,6 +85,24 @@ func TestTokenStore(t *testing.T) {
 			So(cinfo, ShouldBeNil)
 		})
 
+		Convey("Test 4-byte utf8 round-trip", func() {
+			info := &models.Token{
+				ClientID:        "1",
+				UserID:          "1_3",
+				RedirectURI:     "http://localhost/",
+				Scope:           "all \U0001F600 \U0002000B",
+				Access:          "1_3_1",
+				AccessCreateAt:  time.Now(),
+				AccessExpiresIn: time.Second * 5,
+			}
+			err := store.Create(ctx, info)
+			So(err, ShouldBeNil)
+
+			ainfo, err := store.GetByAccess(ctx, info.GetAccess())
+			So(err, ShouldBeNil)
+			So(ainfo.GetScope(), ShouldEqual, info.GetScope())
+		})
+
 		Convey("Test access token store", func() {
 			info := &models.Token{
 				ClientID:        "1",
@@ -116,18 +169,50 @@ func TestTokenStore(t *testing.T) {
 	})
 }
 
+func TestNewStoreWithOpts_ShouldKeepPlainIndexNamesOfExistingTable(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists `custom_table_name`")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT DISTINCT INDEX_NAME FROM information_schema.STATISTICS")).
+		WithArgs("custom_table_name").
+		WillReturnRows(sqlmock.NewRows([]string{"INDEX_NAME"}).
+			AddRow("PRIMARY").
+			AddRow("idx_code").
+			AddRow("idx_expired_at"))
+	for _, name := range []string{"idx_code", "custom_table_name_idx_access_expired", "custom_table_name_idx_refresh_expired", "idx_expired_at", "custom_table_name_idx_user_id"} {
+		mockDB.ExpectExec(regexp.QuoteMeta("create index " + name + " on `custom_table_name`")).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+
+	// ACTION
+	store, err := NewStoreWithOptsE(db, WithTableName("custom_table_name"), WithGCTimeInterval(-1))
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.NotNil(t, store)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
 func TestNewStoreWithOpts_ShouldReturnStoreNotNil(t *testing.T) {
 	// ARRANGE
 	db, mockDB, _ := sqlmock.New()
 	tableName := "custom_table_name"
 
 	// Mock sql exec create table
-	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists `custom_table_name` (`id` bigint not null primary key auto_increment, `expired_at` bigint, `code` varchar(255), `access` varchar(255), `refresh` varchar(255), `data` text) engine=InnoDB charset=UTF8;")).
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists `custom_table_name` (`id` bigint not null primary key auto_increment, `expired_at` bigint, `code` varchar(255), `access` varchar(255), `refresh` varchar(255), `data` text, `user_id` varchar(16)) engine=InnoDB charset=UTF8;")).
 		WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT DISTINCT INDEX_NAME FROM information_schema.STATISTICS")).
+		WithArgs(tableName).
+		WillReturnRows(sqlmock.NewRows([]string{"INDEX_NAME"}).AddRow("PRIMARY"))
+	for _, index := range DefaultIndexes() {
+		mockDB.ExpectExec(regexp.QuoteMeta("create index custom_table_name_" + index.Name + " on `custom_table_name`")).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
 
 	// Mock query:
-	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM custom_table_name WHERE expired_at<=? OR (code='' AND access='' AND refresh='')")).
-		WillReturnRows(sqlmock.NewRows([]string{"count(*)"}).AddRow(0))
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `custom_table_name`")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
 
 	// ACTION
 	store := NewStoreWithOpts(db,
@@ -143,3 +228,3157 @@ func TestNewStoreWithOpts_ShouldReturnStoreNotNil(t *testing.T) {
 	assert.NotNil(t, store.ticker)
 	assert.Equal(t, store.tableName, tableName)
 }
+
+func TestNewStoreWithDBE_ShouldReturnErrorWhenCreateTableFails(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+	createErr := errors.New("access denied")
+
+	// Mock sql exec create table
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists `oauth2_token`")).
+		WillReturnError(createErr)
+
+	// ACTION
+	store, err := NewStoreWithDBE(db, "", 0)
+
+	// ASSERT
+	assert.Nil(t, store)
+	assert.True(t, errors.Is(err, createErr))
+	assert.Contains(t, err.Error(), "mysql: create tables:")
+}
+
+func TestStoreClose_ShouldBeSafeToCallTwice(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	mockDB.ExpectClose()
+
+	// ACTION
+	store.Close()
+	store.Close()
+
+	// ASSERT
+	select {
+	case <-store.done:
+	default:
+		t.Fatal("done channel should be closed")
+	}
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestConfigDialect_ShouldApplyDefaults(t *testing.T) {
+	assert.Equal(t, gorp.MySQLDialect{Engine: "InnoDB", Encoding: "utf8mb4"}, NewConfig(dsn).dialect())
+	assert.Equal(t, gorp.MySQLDialect{Engine: "InnoDB", Encoding: "utf8mb4"}, (&Config{DSN: dsn}).dialect())
+
+	config := NewConfig(dsn)
+	config.Engine = "MyISAM"
+	config.Encoding = "latin1"
+	assert.Equal(t, gorp.MySQLDialect{Engine: "MyISAM", Encoding: "latin1"}, config.dialect())
+}
+
+func TestNewStoreWithDBE_ShouldCreateInnoDBTable(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+	mockDB.ExpectExec(regexp.QuoteMeta("engine=InnoDB charset=utf8mb4;")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDefaultIndexes(mockDB)
+
+	// ACTION
+	store, err := NewStoreWithDBE(db, "", 0)
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.NotNil(t, store)
+	store.Close()
+}
+
+func TestGetByAccess_ShouldReturnContextErrorWhenDeadlineExceeded(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE access=? AND expired_at>? LIMIT 1")).
+		WithArgs("slow", sqlmock.AnyArg()).
+		WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// ACTION
+	info, err := store.GetByAccess(ctx, "slow")
+
+	// ASSERT
+	assert.Nil(t, info)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestWithQueryTimeout_ShouldBoundOperation(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithQueryTimeout(10*time.Millisecond))
+	defer store.Close()
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE access=? AND expired_at>? LIMIT 1")).
+		WithArgs("slow", sqlmock.AnyArg()).
+		WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	start := time.Now()
+
+	// ACTION
+	info, err := store.GetByAccess(context.Background(), "slow")
+
+	// ASSERT
+	assert.Nil(t, info)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Less(t, int64(time.Since(start)), int64(500*time.Millisecond))
+}
+
+func TestWithQueryTimeout_ShouldKeepEarlierCallerDeadline(t *testing.T) {
+	// ARRANGE
+	store, _ := newMockStore(t, WithQueryTimeout(time.Hour))
+	defer store.Close()
+	deadline := time.Now().Add(time.Minute)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	// ACTION
+	opCtx, op := store.startOp(ctx, "GetByAccess")
+	got, ok := opCtx.Deadline()
+	op.end(nil)
+
+	// ASSERT
+	assert.True(t, ok)
+	assert.Equal(t, deadline, got)
+	assert.Error(t, opCtx.Err())
+}
+
+type unmarshalableToken struct {
+	models.Token
+	Ch chan int
+}
+
+func TestCreate_ShouldReturnErrorWhenMarshalFails(t *testing.T) {
+	// ARRANGE
+	store, _ := newMockStore(t)
+	defer store.Close()
+
+	// ACTION
+	err := store.Create(context.Background(), &unmarshalableToken{Token: models.Token{Access: "a"}, Ch: make(chan int)})
+
+	// ASSERT
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "mysql: marshal token:")
+}
+
+func TestGetByAccess_ShouldReturnErrorWhenDataIsMalformed(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE access=? AND expired_at>? LIMIT 1")).
+		WithArgs("broken", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "expired_at", "code", "access", "refresh", "data", "user_id"}).
+			AddRow(1, time.Now().Add(time.Hour).Unix(), "", "broken", "", "{not json", ""))
+
+	// ACTION
+	info, err := store.GetByAccess(context.Background(), "broken")
+
+	// ASSERT
+	assert.Nil(t, info)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "mysql: unmarshal token:")
+}
+
+func TestNewStoreWithDB_ShouldUseDefaultTableNameWhenEmpty(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists `oauth2_token`")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDefaultIndexes(mockDB)
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE `oauth2_token` SET code='' WHERE code=?")).
+		WithArgs("11_11_11").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// ACTION
+	store := NewStoreWithDB(db, "", -1)
+	defer store.Close()
+	err := store.RemoveByCode(context.Background(), "11_11_11")
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultTableName, store.TableName())
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+type appRow struct {
+	ID int64 `db:"id"`
+}
+
+func TestNewStoreWithDbMap_ShouldShareTheMap(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+	dbmap := &gorp.DbMap{Db: db, Dialect: gorp.MySQLDialect{Encoding: DefaultEncoding, Engine: DefaultEngine}}
+	dbmap.AddTableWithName(appRow{}, "app")
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists `oauth2_token`")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDefaultIndexes(mockDB)
+
+	// ACTION
+	store, err := NewStoreWithDbMapE(dbmap, "", -1)
+	_, otherErr := NewStoreWithDbMapE(dbmap, "other_token", -1)
+	_, converterErr := newStore(db, optionFunc(func(store *Store) {
+		store.db = dbmap
+		store.sharedDB = true
+	}), WithNullTokens(true))
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.EqualError(t, otherErr, "mysql: the DbMap already maps mysql.StoreItem to table oauth2_token")
+	assert.EqualError(t, converterErr, "mysql: the type converter of a shared DbMap can't be replaced")
+	store.Close()
+	assert.NoError(t, db.Ping())
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestNewStoreWithDBE_ShouldRejectInvalidTableName(t *testing.T) {
+	for _, tableName := range []string{"oauth2_token; DROP TABLE users", "1token", "token-store", "`token`"} {
+		db, mockDB, _ := sqlmock.New()
+
+		store, err := NewStoreWithDBE(db, tableName, 0)
+
+		assert.Nil(t, store)
+		assert.EqualError(t, err, fmt.Sprintf("mysql: invalid table name %q", tableName))
+		assert.NoError(t, mockDB.ExpectationsWereMet())
+	}
+}
+
+func TestWithTokenNotFoundError_ShouldReturnErrTokenNotFound(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithTokenNotFoundError(true))
+	defer store.Close()
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE access=? AND expired_at>? LIMIT 1")).
+		WithArgs("1_1_1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	// ACTION
+	info, err := store.GetByAccess(context.Background(), "1_1_1")
+	empty, emptyErr := store.GetByRefresh(context.Background(), "")
+
+	// ASSERT
+	assert.Nil(t, info)
+	assert.ErrorIs(t, err, ErrTokenNotFound)
+	assert.Nil(t, empty)
+	assert.ErrorIs(t, emptyErr, ErrTokenNotFound)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestPurgeExpired_ShouldReturnDeletedRows(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token` WHERE expired_at<=? OR (code='' AND access='' AND refresh='') ORDER BY expired_at LIMIT ?")).
+		WithArgs(sqlmock.AnyArg(), DefaultGCBatchSize).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	// ACTION
+	n, err := store.PurgeExpired(context.Background())
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWithGCUnordered_ShouldPurgeWithoutOrderBy(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithGCUnordered(true))
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token` WHERE expired_at<=? OR (code='' AND access='' AND refresh='') LIMIT ?")).
+		WithArgs(sqlmock.AnyArg(), DefaultGCBatchSize).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	// ACTION
+	n, err := store.PurgeExpired(context.Background())
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestPurgeRemoved_ShouldDeleteClearedRows(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithTenant("t1"))
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token` WHERE code='' AND access='' AND refresh='' AND tenant_id=? ORDER BY expired_at LIMIT ?")).
+		WithArgs("t1", DefaultGCBatchSize).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	// ACTION
+	n, err := store.PurgeRemoved(context.Background())
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestPurgeExpired_ShouldDeleteWithoutCounting(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+
+	// a COUNT query before the delete is unexpected and fails the purge
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token` WHERE expired_at<=? OR (code='' AND access='' AND refresh='') ORDER BY expired_at LIMIT ?")).
+		WithArgs(sqlmock.AnyArg(), DefaultGCBatchSize).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// ACTION
+	n, err := store.PurgeExpired(context.Background())
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestNewStoreWithOpts_ShouldNotStartGCWhenIntervalIsNegative(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDefaultIndexes(mockDB)
+
+	// ACTION
+	store := NewStoreWithOpts(db, WithGCTimeInterval(-1))
+	defer store.Close()
+
+	// ASSERT
+	assert.Nil(t, store.ticker)
+}
+
+func TestPurgeExpired_ShouldDeleteInBatches(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithGCBatchSize(2))
+	defer store.Close()
+
+	for _, n := range []int64{2, 2, 1} {
+		mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token` WHERE expired_at<=? OR (code='' AND access='' AND refresh='') ORDER BY expired_at LIMIT ?")).
+			WithArgs(sqlmock.AnyArg(), 2).
+			WillReturnResult(sqlmock.NewResult(0, n))
+	}
+
+	// ACTION
+	n, err := store.PurgeExpired(context.Background())
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestPurgeExpired_ShouldStopBatchingWhenContextIsCancelled(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithGCBatchSize(2))
+	defer store.Close()
+
+	pause := gcBatchPause
+	gcBatchPause = time.Minute
+	defer func() { gcBatchPause = pause }()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token`")).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*20, cancel)
+
+	// ACTION
+	n, err := store.PurgeExpired(ctx)
+
+	// ASSERT
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, int64(2), n)
+}
+
+func TestRemoveByAccess_ShouldClearColumnByDefault(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE `oauth2_token` SET access='' WHERE access=?") + "$").
+		WithArgs("1_1_1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// ACTION
+	err := store.RemoveByAccess(context.Background(), "1_1_1")
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestRemoveByAccess_ShouldDeleteRowWhenHardDeleteIsEnabled(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithHardDelete(true))
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token` WHERE access=?") + "$").
+		WithArgs("1_1_1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE access=? AND expired_at>? LIMIT 1")).
+		WithArgs("1_1_1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "expired_at", "code", "access", "refresh", "data", "user_id"}))
+
+	// ACTION
+	err := store.RemoveByAccess(context.Background(), "1_1_1")
+	info, getErr := store.GetByAccess(context.Background(), "1_1_1")
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.NoError(t, getErr)
+	assert.Nil(t, info)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestBuildQueries_ShouldBuildScopedStatementsOnce(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithTenant("t1"), WithHardDelete(true))
+	defer store.Close()
+
+	// ACTION
+	q := store.queries
+
+	// ASSERT
+	assert.Equal(t, "SELECT * FROM `oauth2_token` WHERE access=? AND expired_at>? AND tenant_id=? LIMIT 1", q.getToken["access"])
+	assert.Equal(t, "SELECT * FROM `oauth2_token` WHERE id=? AND tenant_id=? LIMIT 1", q.getItem["id"])
+	assert.Equal(t, "DELETE FROM `oauth2_token` WHERE refresh=? AND tenant_id=?", q.remove["refresh"])
+	assert.Equal(t, "DELETE FROM `oauth2_token` WHERE (expired_at<=? OR (code='' AND access='' AND refresh='')) AND tenant_id=? ORDER BY expired_at LIMIT ?", q.purge)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestBuildQueries_ShouldSkipSoftDeletedRows(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDefaultIndexes(mockDB)
+	mockDB.ExpectExec(regexp.QuoteMeta("create index idx_deleted_at on")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	store := NewStoreWithOpts(db, WithSoftDelete(30*24*time.Hour), WithGCTimeInterval(-1))
+	defer store.Close()
+
+	// ACTION
+	q := store.queries
+
+	// ASSERT
+	assert.Equal(t, "SELECT * FROM `oauth2_token` WHERE access=? AND expired_at>? AND deleted_at=0 LIMIT 1", q.getToken["access"])
+	assert.Equal(t, "SELECT * FROM `oauth2_token` WHERE id=? LIMIT 1", q.getItem["id"])
+	assert.Equal(t, "UPDATE `oauth2_token` SET deleted_at=? WHERE refresh=? AND deleted_at=0", q.remove["refresh"])
+	assert.Equal(t, "DELETE FROM `oauth2_token` WHERE ((expired_at<=? OR (code='' AND access='' AND refresh='')) AND deleted_at=0) OR (deleted_at<>0 AND deleted_at<=?) ORDER BY expired_at LIMIT ?", q.purge)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestPurgeExpired_ShouldDeleteSoftDeletedRowsAfterRetention(t *testing.T) {
+	// ARRANGE
+	now := time.Unix(1700000000, 0)
+	db, mockDB, _ := sqlmock.New()
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDefaultIndexes(mockDB)
+	mockDB.ExpectExec(regexp.QuoteMeta("create index idx_deleted_at on")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	store := NewStoreWithOpts(db, WithSoftDelete(30*24*time.Hour), WithNowFunc(func() time.Time { return now }), WithGCTimeInterval(-1))
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE `oauth2_token` SET deleted_at=? WHERE access=? AND deleted_at=0")).
+		WithArgs(now.Unix(), "1_1_1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token` WHERE ((expired_at<=?")).
+		WithArgs(now.Unix(), now.Add(-30*24*time.Hour).Unix(), 1000).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	// ACTION
+	err := store.RemoveByAccess(context.Background(), "1_1_1")
+	n, purgeErr := store.PurgeExpired(context.Background())
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.NoError(t, purgeErr)
+	assert.Equal(t, int64(2), n)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWithSoftDelete_ShouldRejectHardDelete(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+
+	// ACTION
+	_, err := NewStoreWithOptsE(db, WithGCTimeInterval(-1), WithHardDelete(true), WithSoftDelete(time.Hour))
+
+	// ASSERT
+	assert.EqualError(t, err, "mysql: WithSoftDelete can't be combined with WithHardDelete")
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestRemoveByRefreshCount_ShouldReturnRowsAffected(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE `oauth2_token` SET refresh='' WHERE refresh=?")).
+		WithArgs("2_2_2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE `oauth2_token` SET refresh='' WHERE refresh=?")).
+		WithArgs("2_2_2").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// ACTION
+	first, firstErr := store.RemoveByRefreshCount(context.Background(), "2_2_2")
+	second, secondErr := store.RemoveByRefreshCount(context.Background(), "2_2_2")
+
+	// ASSERT
+	assert.NoError(t, firstErr)
+	assert.Equal(t, int64(1), first)
+	assert.NoError(t, secondErr)
+	assert.Equal(t, int64(0), second)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestTouch_ShouldExtendUnexpiredAccessTokens(t *testing.T) {
+	// ARRANGE
+	now := time.Unix(1000, 0)
+	store, mockDB := newMockStore(t, WithNowFunc(func() time.Time { return now }))
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE `oauth2_token` SET expired_at=CASE WHEN expired_at<? THEN ? ELSE expired_at END WHERE access=? AND expired_at>?")).
+		WithArgs(int64(4600), int64(4600), "1_1_1", int64(1000)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT 1 FROM `oauth2_token` WHERE access=? AND expired_at>? LIMIT 1")).
+		WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(1))
+
+	// ACTION
+	touched, err := store.Touch(context.Background(), "1_1_1", now.Add(time.Hour))
+	_, pastErr := store.Touch(context.Background(), "1_1_1", now)
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.True(t, touched)
+	assert.ErrorIs(t, pastErr, ErrTokenExpired)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestTouch_ShouldNotFindExpiredAccessTokenWithSplitExpiry(t *testing.T) {
+	// ARRANGE
+	now := time.Unix(1000, 0)
+	db, mockDB, _ := sqlmock.New()
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDefaultIndexes(mockDB)
+	mockDB.ExpectExec(regexp.QuoteMeta("create index idx_access_expired_at on")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	store := NewStoreWithOpts(db, WithSplitExpiry(true), WithNowFunc(func() time.Time { return now }), WithGCTimeInterval(-1))
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE `oauth2_token` SET access_expired_at=?, expired_at=CASE WHEN expired_at<? THEN ? ELSE expired_at END WHERE access=? AND access_expired_at>?")).
+		WithArgs(int64(4600), int64(4600), int64(4600), "1_1_1", int64(1000)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT 1 FROM `oauth2_token` WHERE access=? AND access_expired_at>? LIMIT 1")).
+		WithArgs("1_1_1", int64(1000)).
+		WillReturnRows(sqlmock.NewRows([]string{"1"}))
+
+	// ACTION
+	touched, err := store.Touch(context.Background(), "1_1_1", now.Add(time.Hour))
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.False(t, touched)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestExistsByAccess_ShouldCheckAccessExpiryWithSplitExpiry(t *testing.T) {
+	// ARRANGE
+	now := time.Unix(1000, 0)
+	db, mockDB, _ := sqlmock.New()
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDefaultIndexes(mockDB)
+	mockDB.ExpectExec(regexp.QuoteMeta("create index idx_access_expired_at on")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	store := NewStoreWithOpts(db, WithSplitExpiry(true), WithNowFunc(func() time.Time { return now }), WithGCTimeInterval(-1))
+	defer store.Close()
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT 1 FROM `oauth2_token` WHERE access=? AND access_expired_at>? LIMIT 1")).
+		WithArgs("1_1_1", int64(1000)).
+		WillReturnRows(sqlmock.NewRows([]string{"1"}))
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT 1 FROM `oauth2_token` WHERE refresh=? AND expired_at>? LIMIT 1")).
+		WithArgs("2_2_2", int64(1000)).
+		WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(1))
+
+	// ACTION
+	access, err := store.ExistsByAccess(context.Background(), "1_1_1")
+	refresh, refreshErr := store.ExistsByRefresh(context.Background(), "2_2_2")
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.False(t, access)
+	assert.NoError(t, refreshErr)
+	assert.True(t, refresh)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestTruncateAll_ShouldTruncateStoreTable(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("TRUNCATE TABLE `oauth2_token`")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// ACTION
+	err := store.TruncateAll(context.Background())
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestTruncateAll_ShouldRefuseTenantScopedStores(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithTenant("t1"))
+	defer store.Close()
+
+	// ACTION
+	err := store.TruncateAll(context.Background())
+
+	// ASSERT
+	assert.EqualError(t, err, "mysql: truncate: the table of a tenant scoped store is shared with other tenants")
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestDeleteAll_ShouldDeleteEveryRow(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+	tenant, tenantMock := newMockStore(t, WithTenant("t1"))
+	defer tenant.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token`") + "$").
+		WithArgs().
+		WillReturnResult(sqlmock.NewResult(0, 5))
+	tenantMock.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token` WHERE tenant_id=?")).
+		WithArgs("t1").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	// ACTION
+	n, err := store.DeleteAll(context.Background())
+	tenantN, tenantErr := tenant.DeleteAll(context.Background())
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+	assert.NoError(t, tenantErr)
+	assert.Equal(t, int64(2), tenantN)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, tenantMock.ExpectationsWereMet())
+}
+
+func TestCount_ShouldCountActiveAndAllTokens(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM `oauth2_token` WHERE expired_at>?")).
+		WillReturnRows(sqlmock.NewRows([]string{"count(*)"}).AddRow(7))
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM `oauth2_token`")).
+		WillReturnRows(sqlmock.NewRows([]string{"count(*)"}).AddRow(9))
+
+	// ACTION
+	active, err := store.Count(context.Background())
+	assert.NoError(t, err)
+	all, err := store.CountAll(context.Background())
+	assert.NoError(t, err)
+
+	// ASSERT
+	assert.Equal(t, int64(7), active)
+	assert.Equal(t, int64(9), all)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestExpiryHistogram_ShouldCountEveryBucketInOneQuery(t *testing.T) {
+	// ARRANGE
+	now := time.Unix(1000, 0)
+	store, mockDB := newMockStore(t, WithNowFunc(func() time.Time { return now }))
+	defer store.Close()
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(CASE WHEN expired_at<=? THEN 1 END), COUNT(CASE WHEN expired_at<=? THEN 1 END) "+
+		"FROM `oauth2_token` WHERE expired_at>? AND expired_at<=?")).
+		WithArgs(int64(4600), int64(87400), int64(1000), int64(87400)).
+		WillReturnRows(sqlmock.NewRows([]string{"hour", "day"}).AddRow(2, 5))
+
+	// ACTION
+	histogram, err := store.ExpiryHistogram(context.Background(), []time.Duration{time.Hour, 24 * time.Hour})
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, map[time.Duration]int64{time.Hour: 2, 24 * time.Hour: 5}, histogram)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestExpiryHistogram_ShouldRejectNonPositiveBuckets(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+
+	// ACTION
+	_, err := store.ExpiryHistogram(context.Background(), []time.Duration{time.Hour, 0})
+
+	// ASSERT
+	assert.EqualError(t, err, "mysql: expiry histogram: invalid bucket 0s")
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestGetItemByID_ShouldReturnRawItem(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+
+	expiredAt := time.Now().Add(time.Hour).Unix()
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE id=? LIMIT 1")).
+		WithArgs(42).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "expired_at", "code", "access", "refresh", "data", "user_id"}).
+			AddRow(42, expiredAt, "", "1_1_1", "", `{"UserID":"1_1","Access":"1_1_1"}`, "1_1"))
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE id=? AND expired_at>? LIMIT 1")).
+		WithArgs(42, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "expired_at", "code", "access", "refresh", "data", "user_id"}).
+			AddRow(42, expiredAt, "", "1_1_1", "", `{"UserID":"1_1","Access":"1_1_1"}`, "1_1"))
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE id=? AND expired_at>? LIMIT 1")).
+		WithArgs(43, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "expired_at", "code", "access", "refresh", "data", "user_id"}))
+
+	// ACTION
+	item, err := store.GetItemByID(context.Background(), 42)
+	assert.NoError(t, err)
+	info, err := store.GetByID(context.Background(), 42)
+	assert.NoError(t, err)
+	missing, err := store.GetByID(context.Background(), 43)
+	assert.NoError(t, err)
+
+	// ASSERT
+	assert.Equal(t, &StoreItem{ID: 42, ExpiredAt: expiredAt, Access: "1_1_1", Data: `{"UserID":"1_1","Access":"1_1_1"}`, UserID: "1_1"}, item)
+	assert.Equal(t, "1_1", info.GetUserID())
+	assert.Nil(t, missing)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestGetItemByAccess_ShouldReturnRawItem(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+
+	expiredAt := time.Now().Add(time.Hour).Unix()
+	columns := []string{"id", "expired_at", "code", "access", "refresh", "data", "user_id"}
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE access=? LIMIT 1")).
+		WithArgs("1_1_1").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(42, expiredAt, "", "1_1_1", "2_2_2", `stale`, "1_1"))
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE refresh=? LIMIT 1")).
+		WithArgs("2_2_2").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(42, expiredAt, "", "1_1_1", "2_2_2", `stale`, "1_1"))
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE code=? LIMIT 1")).
+		WithArgs("missing").
+		WillReturnRows(sqlmock.NewRows(columns))
+
+	// ACTION
+	byAccess, err := store.GetItemByAccess(context.Background(), "1_1_1")
+	assert.NoError(t, err)
+	byRefresh, err := store.GetItemByRefresh(context.Background(), "2_2_2")
+	assert.NoError(t, err)
+	missing, err := store.GetItemByCode(context.Background(), "missing")
+	assert.NoError(t, err)
+	empty, err := store.GetItemByCode(context.Background(), "")
+	assert.NoError(t, err)
+
+	// ASSERT
+	expected := &StoreItem{ID: 42, ExpiredAt: expiredAt, Access: "1_1_1", Refresh: "2_2_2", Data: `stale`, UserID: "1_1"}
+	assert.Equal(t, expected, byAccess)
+	assert.Equal(t, expected, byRefresh)
+	assert.Nil(t, missing)
+	assert.Nil(t, empty)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestNewStoreWithOpts_ShouldApplyEngineAndCharset(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+	mockDB.ExpectExec(regexp.QuoteMeta("engine=MyISAM charset=latin1;")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDefaultIndexes(mockDB)
+	var stdout bytes.Buffer
+
+	// ACTION
+	store := NewStoreWithOpts(db,
+		WithEngine("MyISAM"),
+		WithCharset("latin1"),
+		WithGCInterval(time.Minute),
+		WithStdout(&stdout),
+	)
+	defer store.Close()
+
+	// ASSERT
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.Equal(t, time.Minute, store.gcInterval)
+	assert.Equal(t, NewWriterLogger(&stdout), store.logger)
+}
+
+// captureArg is a sqlmock argument matcher remembering the matched value
+type captureArg struct {
+	value interface{}
+}
+
+func (a *captureArg) Match(v driver.Value) bool {
+	a.value = v
+	return true
+}
+
+func TestCreate_ShouldRoundTripFourByteCharacters(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+
+	info := &models.Token{
+		UserID:          "\U0001F600",
+		Scope:           "emoji \U0001F680 and cjk \U0002000B",
+		Access:          "1_1_1",
+		AccessCreateAt:  time.Now(),
+		AccessExpiresIn: time.Hour,
+	}
+	data := &captureArg{}
+	mockDB.ExpectExec(regexp.QuoteMeta("insert into `oauth2_token`")).
+		WithArgs(sqlmock.AnyArg(), "", "1_1_1", "", data, info.UserID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	// ACTION
+	err := store.Create(context.Background(), info)
+	assert.NoError(t, err)
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE access=? AND expired_at>? LIMIT 1")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "expired_at", "code", "access", "refresh", "data", "user_id"}).
+			AddRow(1, time.Now().Add(time.Hour).Unix(), "", "1_1_1", "", data.value, info.UserID))
+	got, err := store.GetByAccess(context.Background(), "1_1_1")
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(info.Scope), []byte(got.GetScope()))
+	assert.Equal(t, []byte(info.UserID), []byte(got.GetUserID()))
+}
+
+type recordLogger struct {
+	lines []string
+}
+
+func (l *recordLogger) Errorf(format string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func TestClean_ShouldReportErrorsToLogger(t *testing.T) {
+	// ARRANGE
+	logger := &recordLogger{}
+	store, mockDB := newMockStore(t, WithLogger(logger))
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token`")).
+		WillReturnError(errors.New("connection reset"))
+
+	// ACTION
+	store.clean()
+
+	// ASSERT
+	assert.Equal(t, []string{"connection reset"}, logger.lines)
+}
+
+func TestClean_ShouldLogMissingTableOnce(t *testing.T) {
+	// ARRANGE
+	logger := &recordLogger{}
+	store, mockDB := newMockStore(t, WithLogger(logger))
+	defer store.Close()
+
+	missing := &mysqldriver.MySQLError{Number: 1146, Message: "Table 'db.oauth2_token' doesn't exist"}
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token`")).WillReturnError(missing)
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token`")).WillReturnError(missing)
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token`")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token`")).WillReturnError(missing)
+
+	// ACTION
+	for i := 0; i < 4; i++ {
+		store.clean()
+	}
+
+	// ASSERT
+	line := "gc: table oauth2_token does not exist, retrying silently until it does: " + missing.Error()
+	assert.Equal(t, []string{line, line}, logger.lines)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestClean_ShouldStopGCOnMissingTable(t *testing.T) {
+	// ARRANGE
+	logger := &recordLogger{}
+	store, mockDB := newMockStore(t, WithLogger(logger), WithGCTimeInterval(3600), WithGCMissingTable(MissingTableStop))
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token`")).
+		WillReturnError(&mysqldriver.MySQLError{Number: 1146, Message: "no table"})
+
+	// ACTION
+	store.clean()
+
+	// ASSERT
+	assert.Equal(t, []string{"gc stopped: table oauth2_token does not exist: Error 1146: no table"}, logger.lines)
+	store.mu.Lock()
+	assert.Zero(t, store.gcInterval)
+	store.mu.Unlock()
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestClean_ShouldRecreateMissingTable(t *testing.T) {
+	// ARRANGE
+	logger := &infoLogger{}
+	store, mockDB := newMockStore(t, WithLogger(logger), WithGCMissingTable(MissingTableRecreate))
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token`")).
+		WillReturnError(&mysqldriver.MySQLError{Number: 1146, Message: "no table"})
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists `oauth2_token`")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDefaultIndexes(mockDB)
+	// a change of the mapping shows whether the gc registered the table again
+	store.tableMap.ColMap("Code").SetMaxSize(64)
+
+	// ACTION
+	store.clean()
+
+	// ASSERT
+	assert.Empty(t, logger.lines)
+	assert.Equal(t, []string{"gc re-created missing table oauth2_token"}, logger.infos)
+	assert.Equal(t, 64, store.tableMap.ColMap("Code").MaxSize, "the table map is only registered once")
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestSetStdout_ShouldWrapWriter(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+	var stdout bytes.Buffer
+
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token`")).
+		WillReturnError(errors.New("connection reset"))
+
+	// ACTION
+	store.SetStdout(&stdout).clean()
+
+	// ASSERT
+	assert.Equal(t, "[OAUTH2-MYSQL-ERROR]: connection reset", stdout.String())
+}
+
+type recordMetrics struct {
+	deleted []int64
+	errs    []error
+	panic   bool
+}
+
+func (m *recordMetrics) ObserveGC(deleted int64, duration time.Duration, err error) {
+	m.deleted = append(m.deleted, deleted)
+	m.errs = append(m.errs, err)
+	if m.panic {
+		panic("boom")
+	}
+}
+
+func TestClean_ShouldObserveGCMetrics(t *testing.T) {
+	// ARRANGE
+	metrics := &recordMetrics{}
+	store, mockDB := newMockStore(t, WithMetrics(metrics), WithLogger(nil))
+	defer store.Close()
+
+	gcErr := errors.New("connection reset")
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token`")).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token`")).
+		WillReturnError(gcErr)
+
+	// ACTION
+	store.clean()
+	store.clean()
+
+	// ASSERT
+	assert.Equal(t, []int64{2, 0}, metrics.deleted)
+	assert.Equal(t, []error{nil, gcErr}, metrics.errs)
+}
+
+func TestClean_ShouldRecoverFromPanickingMetrics(t *testing.T) {
+	// ARRANGE
+	logger := &recordLogger{}
+	store, mockDB := newMockStore(t, WithMetrics(&recordMetrics{panic: true}), WithLogger(logger))
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token`")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// ACTION
+	assert.NotPanics(t, store.clean)
+
+	// ASSERT
+	assert.Equal(t, []string{"metrics hook panic: boom"}, logger.lines)
+}
+
+func TestClean_ShouldOnlyCountInDryRun(t *testing.T) {
+	// ARRANGE
+	logger := &infoLogger{}
+	metrics := &recordMetrics{}
+	store, mockDB := newMockStore(t, WithGCDryRun(true), WithLogger(logger), WithMetrics(metrics))
+	defer store.Close()
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM `oauth2_token` WHERE expired_at<=? OR (code='' AND access='' AND refresh='')")).
+		WillReturnRows(sqlmock.NewRows([]string{"count(*)"}).AddRow(3))
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token`")).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	// ACTION
+	store.clean()
+	store.SetGCDryRun(false)
+	store.clean()
+
+	// ASSERT
+	assert.Equal(t, []string{"gc dry run: would delete 3 rows from oauth2_token", "gc deleted 3 rows from oauth2_token"}, logger.infos)
+	assert.Equal(t, []int64{3, 3}, metrics.deleted)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestClean_ShouldResetAutoIncrementOfEmptyTable(t *testing.T) {
+	// ARRANGE
+	logger := &infoLogger{}
+	store, mockDB := newMockStore(t, WithEngine("MyISAM"), WithGCResetAutoIncrement(true), WithLogger(logger))
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token`")).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM `oauth2_token`")).
+		WillReturnRows(sqlmock.NewRows([]string{"count(*)"}).AddRow(0))
+	mockDB.ExpectExec(regexp.QuoteMeta("ALTER TABLE `oauth2_token` AUTO_INCREMENT = 1")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// ACTION
+	store.clean()
+
+	// ASSERT
+	assert.Empty(t, logger.lines)
+	assert.Equal(t, []string{"gc reset the autoincrement of the empty table oauth2_token", "gc deleted 3 rows from oauth2_token"}, logger.infos)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWithGCResetAutoIncrement_ShouldRequireMyISAM(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+
+	// ACTION
+	_, err := NewStoreWithOptsE(db, WithGCTimeInterval(-1), WithGCResetAutoIncrement(true))
+
+	// ASSERT
+	assert.EqualError(t, err, "mysql: WithGCResetAutoIncrement requires the MyISAM engine")
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestAuditHooks_ShouldReportRedactedTokens(t *testing.T) {
+	// ARRANGE
+	var created []StoreItem
+	var removed []string
+	store, mockDB := newMockStore(t, WithAuditHooks(AuditHooks{
+		OnCreate: func(item StoreItem) { created = append(created, item) },
+		OnRemove: func(kind, value string) { removed = append(removed, kind+" "+value) },
+	}))
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("insert into `oauth2_token`")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE `oauth2_token` SET access='' WHERE access=?")).
+		WithArgs("access-token-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE `oauth2_token` SET access='' WHERE access=?")).
+		WithArgs("access-token-2").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token` WHERE user_id=?")).
+		WithArgs("user-1").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	// ACTION
+	err := store.Create(context.Background(), &models.Token{
+		UserID:          "user-1",
+		Access:          "access-token-1",
+		AccessCreateAt:  time.Now(),
+		AccessExpiresIn: time.Hour,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, store.RemoveByAccess(context.Background(), "access-token-1"))
+	assert.NoError(t, store.RemoveByAccess(context.Background(), "access-token-2"))
+	_, err = store.RemoveByUserID(context.Background(), "user-1")
+	assert.NoError(t, err)
+
+	// ASSERT
+	if assert.Len(t, created, 1) {
+		assert.Equal(t, "", created[0].Code)
+		assert.Equal(t, "****en-1", created[0].Access)
+		assert.Equal(t, "user-1", created[0].UserID)
+		assert.NotContains(t, created[0].Data, "access-token-1")
+	}
+	assert.Equal(t, []string{"access ****en-1", "user_id user-1"}, removed)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestAuditHooks_ShouldPassRawTokensWhenAllowed(t *testing.T) {
+	// ARRANGE
+	var removed []string
+	store, mockDB := newMockStore(t, WithAuditHooks(AuditHooks{
+		OnRemove:  func(kind, value string) { removed = append(removed, kind+" "+value) },
+		RawTokens: true,
+	}))
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE `oauth2_token` SET refresh='' WHERE refresh=?")).
+		WithArgs("refresh-token-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// ACTION
+	err := store.RemoveByRefresh(context.Background(), "refresh-token-1")
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"refresh refresh-token-1"}, removed)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestAuditHooks_ShouldRecoverFromPanic(t *testing.T) {
+	// ARRANGE
+	logger := &infoLogger{}
+	store, mockDB := newMockStore(t, WithLogger(logger), WithAuditHooks(AuditHooks{
+		OnCreate: func(StoreItem) { panic("siem down") },
+	}))
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("insert into `oauth2_token`")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	// ACTION
+	err := store.Create(context.Background(), &models.Token{
+		Access:          "access-token-1",
+		AccessCreateAt:  time.Now(),
+		AccessExpiresIn: time.Hour,
+	})
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"audit hook panic: siem down"}, logger.lines)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+type debugLogger struct {
+	infoLogger
+	debugs []string
+}
+
+func (l *debugLogger) Debugf(format string, args ...interface{}) {
+	l.debugs = append(l.debugs, fmt.Sprintf(format, args...))
+}
+
+func TestClean_ShouldFilterLinesBelowLogLevel(t *testing.T) {
+	tests := []struct {
+		level  LogLevel
+		infos  []string
+		debugs []string
+	}{
+		{LogLevelDebug, []string{"gc deleted 2 rows from oauth2_token"}, []string{"gc deleted no rows from oauth2_token"}},
+		{0, []string{"gc deleted 2 rows from oauth2_token"}, nil},
+		{LogLevelError, nil, nil},
+	}
+	for _, test := range tests {
+		// ARRANGE
+		logger := &debugLogger{}
+		store, mockDB := newMockStore(t, WithLogger(logger), WithLogLevel(test.level))
+		mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token`")).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token`")).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token`")).
+			WillReturnError(errors.New("server has gone away"))
+
+		// ACTION
+		store.clean()
+		store.clean()
+		store.clean()
+		store.Close()
+
+		// ASSERT
+		assert.Equal(t, test.infos, logger.infos)
+		assert.Equal(t, test.debugs, logger.debugs)
+		assert.Equal(t, []string{"server has gone away"}, logger.lines)
+		assert.NoError(t, mockDB.ExpectationsWereMet())
+	}
+}
+
+func TestClean_ShouldEvictRowsOverMaxRows(t *testing.T) {
+	// ARRANGE
+	logger := &infoLogger{}
+	store, mockDB := newMockStore(t, WithMaxRows(5), WithGCBatchSize(2), WithLogger(logger))
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token` WHERE expired_at<=?")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM `oauth2_token`")).
+		WillReturnRows(sqlmock.NewRows([]string{"count(*)"}).AddRow(8))
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token` ORDER BY expired_at, id LIMIT ?")).
+		WithArgs(int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token` ORDER BY expired_at, id LIMIT ?")).
+		WithArgs(int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// ACTION
+	store.clean()
+
+	// ASSERT
+	assert.Equal(t, []string{"gc evicted 3 rows from oauth2_token over the 5 rows cap", "gc deleted 4 rows from oauth2_token"}, logger.infos)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestEvictOverflow_ShouldBindTenantBeforeBatchSize(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithTenant("t1"), WithMaxRows(5))
+	defer store.Close()
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM `oauth2_token` WHERE tenant_id=?")).
+		WithArgs("t1").
+		WillReturnRows(sqlmock.NewRows([]string{"count(*)"}).AddRow(6))
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token` WHERE tenant_id=? ORDER BY expired_at, id LIMIT ?")).
+		WithArgs("t1", int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// ACTION
+	n, err := store.EvictOverflow(context.Background())
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestPing_ShouldPingDatabase(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDefaultIndexes(mockDB)
+	store := NewStoreWithOpts(db, WithGCTimeInterval(-1))
+	defer store.Close()
+
+	pingErr := errors.New("server has gone away")
+	mockDB.ExpectPing()
+	mockDB.ExpectPing().WillReturnError(pingErr)
+
+	// ACTION & ASSERT
+	assert.NoError(t, store.Ping(context.Background()))
+	assert.Equal(t, pingErr, store.Ping(context.Background()))
+}
+
+func TestStats_ShouldReflectPoolSettings(t *testing.T) {
+	// ARRANGE
+	store, _ := newMockStore(t)
+	defer store.Close()
+
+	// ACTION
+	store.db.Db.SetMaxOpenConns(12)
+	stats := store.Stats()
+
+	// ASSERT
+	assert.Equal(t, 12, stats.MaxOpenConnections)
+}
+
+type tenantToken struct {
+	models.Token
+	TenantID string
+}
+
+func TestGetByAccess_ShouldDecodeIntoCustomToken(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithTokenFactory(func() oauth2.TokenInfo {
+		return &tenantToken{}
+	}))
+	defer store.Close()
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE access=? AND expired_at>? LIMIT 1")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "expired_at", "code", "access", "refresh", "data", "user_id"}).
+			AddRow(1, time.Now().Add(time.Hour).Unix(), "", "1_1_1", "", `{"Access":"1_1_1","TenantID":"acme"}`, ""))
+
+	// ACTION
+	info, err := store.GetByAccess(context.Background(), "1_1_1")
+
+	// ASSERT
+	assert.NoError(t, err)
+	if assert.IsType(t, &tenantToken{}, info) {
+		assert.Equal(t, "acme", info.(*tenantToken).TenantID)
+		assert.Equal(t, "1_1_1", info.GetAccess())
+	}
+}
+
+func TestCreateTx_ShouldInsertWithinTransaction(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+	ctx := context.Background()
+
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec(regexp.QuoteMeta("insert into `oauth2_token`")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE users SET last_login=?")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectCommit()
+
+	// ACTION
+	tx, err := store.Begin(ctx)
+	assert.NoError(t, err)
+	err = store.CreateTx(ctx, tx, &models.Token{Access: "1_1_1", AccessCreateAt: time.Now(), AccessExpiresIn: time.Hour})
+	assert.NoError(t, err)
+	_, err = tx.Exec("UPDATE users SET last_login=?", time.Now().Unix())
+	assert.NoError(t, err)
+
+	// ASSERT
+	assert.NoError(t, tx.Commit())
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestNewStoreWithOpts_ShouldCreateConfiguredIndexes(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectExec(regexp.QuoteMeta("create index idx_access_user on `oauth2_token` (`access`, `user_id`) using btree;")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// ACTION
+	store := NewStoreWithOpts(db,
+		WithGCTimeInterval(-1),
+		WithIndexes(Index{Name: "idx_access_user", Columns: []string{"access", "user_id"}}),
+	)
+	defer store.Close()
+
+	// ASSERT
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestNewStoreWithDBE_ShouldTolerateExistingIndexes(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, index := range DefaultIndexes() {
+		mockDB.ExpectExec(regexp.QuoteMeta("create index " + index.Name + " on")).
+			WillReturnError(&mysqldriver.MySQLError{Number: 1061, Message: "Duplicate key name '" + index.Name + "'"})
+	}
+
+	// ACTION
+	store, err := NewStoreWithDBE(db, "", -1)
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.NotNil(t, store)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestNewStoreWithDBE_ShouldReturnErrorWhenCreateIndexFails(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+	indexErr := &mysqldriver.MySQLError{Number: 1142, Message: "INDEX command denied"}
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectExec(regexp.QuoteMeta("create index idx_code on")).
+		WillReturnError(indexErr)
+
+	// ACTION
+	store, err := NewStoreWithDBE(db, "", -1)
+
+	// ASSERT
+	assert.Nil(t, store)
+	assert.True(t, errors.Is(err, indexErr))
+	assert.Contains(t, err.Error(), "mysql: create index idx_code:")
+}
+
+func largeToken() *models.Token {
+	return &models.Token{
+		UserID:          "1_1",
+		Scope:           strings.Repeat("scope:read scope:write ", 5000),
+		Access:          "1_1_1",
+		AccessCreateAt:  time.Now(),
+		AccessExpiresIn: time.Hour,
+	}
+}
+
+func TestCreate_ShouldRejectDataTooLong(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+
+	// ACTION
+	err := store.Create(context.Background(), largeToken())
+
+	// ASSERT
+	assert.True(t, errors.Is(err, ErrDataTooLong))
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestCreate_ShouldFitLargeTokenWhenCompressed(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"compression":            {WithCompression(true)},
+		"compression and cipher": {WithCompression(true), WithCipher(mustAESGCMCipher(t))},
+	} {
+		t.Run(name, func(t *testing.T) {
+			// ARRANGE
+			store, mockDB := newMockStore(t, opts...)
+			defer store.Close()
+
+			info := largeToken()
+			data := &captureArg{}
+			mockDB.ExpectExec(regexp.QuoteMeta("insert into `oauth2_token`")).
+				WithArgs(sqlmock.AnyArg(), "", "1_1_1", "", data, "1_1").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			// ACTION
+			err := store.Create(context.Background(), info)
+			assert.NoError(t, err)
+
+			mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE access=? AND expired_at>? LIMIT 1")).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "expired_at", "code", "access", "refresh", "data", "user_id"}).
+					AddRow(1, time.Now().Add(time.Hour).Unix(), "", "1_1_1", "", data.value, "1_1"))
+			got, err := store.GetByAccess(context.Background(), "1_1_1")
+
+			// ASSERT
+			assert.NoError(t, err)
+			assert.Less(t, len(data.value.(string)), len(info.Scope))
+			assert.Equal(t, info.Scope, got.GetScope())
+		})
+	}
+}
+
+func TestGetByAccess_ShouldReadUncompressedRowsWhenCompressionIsEnabled(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithCompression(true))
+	defer store.Close()
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE access=? AND expired_at>? LIMIT 1")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "expired_at", "code", "access", "refresh", "data", "user_id"}).
+			AddRow(1, time.Now().Add(time.Hour).Unix(), "", "1_1_1", "", `{"UserID":"1_1","Access":"1_1_1"}`, "1_1"))
+
+	// ACTION
+	info, err := store.GetByAccess(context.Background(), "1_1_1")
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, "1_1", info.GetUserID())
+}
+
+func TestNewStoreWithOpts_ShouldApplyColumnSizes(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+	mockDB.ExpectExec(regexp.QuoteMeta("`code` varchar(128), `access` text, `refresh` text, `data` text,")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectExec(regexp.QuoteMeta("create index idx_code on `oauth2_token` (`code`) using btree;")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectExec(regexp.QuoteMeta("create index idx_access_expired on `oauth2_token` (`access`(255), `expired_at`) using btree;")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectExec(regexp.QuoteMeta("create index idx_refresh_expired on `oauth2_token` (`refresh`(255), `expired_at`) using btree;")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectExec(regexp.QuoteMeta("create index idx_expired_at on")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectExec(regexp.QuoteMeta("create index idx_user_id on")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// ACTION
+	store := NewStoreWithOpts(db,
+		WithGCTimeInterval(-1),
+		WithColumnSizes(ColumnSizes{Code: 128, Access: 2048, Refresh: 2048, Data: 8192}),
+	)
+	defer store.Close()
+
+	// ASSERT
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.Equal(t, ColumnSizes{Code: 128, Access: 2048, Refresh: 2048, Data: 8192}, store.sizes)
+}
+
+func TestCreate_ShouldRejectDataLongerThanVarcharColumn(t *testing.T) {
+	// ARRANGE
+	store, _ := newMockStore(t, WithColumnSizes(ColumnSizes{Data: 32}))
+	defer store.Close()
+
+	// ACTION
+	err := store.Create(context.Background(), &models.Token{Access: "1_1_1", Scope: "all", AccessCreateAt: time.Now(), AccessExpiresIn: time.Hour})
+
+	// ASSERT
+	assert.True(t, errors.Is(err, ErrDataTooLong))
+}
+
+func TestMigrate_ShouldWidenColumnsAndConvertEngine(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithColumnSizes(ColumnSizes{Code: 200, Access: 2048}))
+	defer store.Close()
+
+	columns := []string{"COLUMN_NAME", "DATA_TYPE", "CHARACTER_MAXIMUM_LENGTH", "ENGINE"}
+	mockDB.ExpectQuery(regexp.QuoteMeta("FROM information_schema.COLUMNS")).
+		WithArgs("oauth2_token").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("id", "bigint", nil, "MyISAM").
+			AddRow("code", "varchar", 100, "MyISAM").
+			AddRow("access", "varchar", 255, "MyISAM").
+			AddRow("refresh", "varchar", 255, "MyISAM").
+			AddRow("data", "text", 65535, "MyISAM"))
+	mockDB.ExpectQuery(regexp.QuoteMeta("FROM information_schema.STATISTICS")).
+		WithArgs("oauth2_token").
+		WillReturnRows(sqlmock.NewRows([]string{"INDEX_NAME", "COLUMN_NAME", "SUB_PART", "NON_UNIQUE"}).
+			AddRow("PRIMARY", "id", nil, false).
+			AddRow("idx_access_expired", "access", nil, true).
+			AddRow("idx_access_expired", "expired_at", nil, true).
+			AddRow("idx_code", "code", nil, true))
+	alterAccess := "ALTER TABLE `oauth2_token` DROP INDEX `idx_access_expired`, MODIFY `access` text, " +
+		"ADD INDEX `idx_access_expired` (`access`(255), `expired_at`)"
+	mockDB.ExpectExec(regexp.QuoteMeta("ALTER TABLE `oauth2_token` MODIFY `code` varchar(200)")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectExec(regexp.QuoteMeta(alterAccess)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectExec(regexp.QuoteMeta("ALTER TABLE `oauth2_token` ENGINE=InnoDB")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// ACTION
+	stmts, err := store.Migrate(context.Background())
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"ALTER TABLE `oauth2_token` MODIFY `code` varchar(200)",
+		alterAccess,
+		"ALTER TABLE `oauth2_token` ENGINE=InnoDB",
+	}, stmts)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestMigrate_ShouldRefuseTextColumnWithUniqueIndex(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithColumnSizes(ColumnSizes{Access: 2048}))
+	defer store.Close()
+
+	columns := []string{"COLUMN_NAME", "DATA_TYPE", "CHARACTER_MAXIMUM_LENGTH", "ENGINE"}
+	mockDB.ExpectQuery(regexp.QuoteMeta("FROM information_schema.COLUMNS")).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("access", "varchar", 255, "InnoDB"))
+	mockDB.ExpectQuery(regexp.QuoteMeta("FROM information_schema.STATISTICS")).
+		WillReturnRows(sqlmock.NewRows([]string{"INDEX_NAME", "COLUMN_NAME", "SUB_PART", "NON_UNIQUE"}).
+			AddRow("uniq_access", "access", nil, false))
+
+	// ACTION
+	stmts, err := store.Migrate(context.Background())
+
+	// ASSERT
+	assert.Empty(t, stmts)
+	assert.EqualError(t, err, "mysql: migrate: unique index uniq_access can't be kept on TEXT column access, drop it first")
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestMigrate_ShouldBeNoopWhenTableMatches(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+
+	columns := []string{"COLUMN_NAME", "DATA_TYPE", "CHARACTER_MAXIMUM_LENGTH", "ENGINE"}
+	mockDB.ExpectQuery(regexp.QuoteMeta("FROM information_schema.COLUMNS")).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("code", "varchar", 255, "InnoDB").
+			AddRow("access", "varchar", 255, "InnoDB").
+			AddRow("refresh", "varchar", 255, "InnoDB").
+			AddRow("data", "text", 65535, "InnoDB"))
+
+	// ACTION
+	stmts, err := store.Migrate(context.Background())
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Empty(t, stmts)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+type infoLogger struct {
+	recordLogger
+	infos []string
+}
+
+func (l *infoLogger) Infof(format string, args ...interface{}) {
+	l.infos = append(l.infos, fmt.Sprintf(format, args...))
+}
+
+func TestConvertEngine_ShouldAlterTableAndReportRows(t *testing.T) {
+	// ARRANGE
+	logger := &infoLogger{}
+	store, mockDB := newMockStore(t, WithLogger(logger))
+	defer store.Close()
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT ENGINE, TABLE_ROWS FROM information_schema.TABLES")).
+		WithArgs("oauth2_token").
+		WillReturnRows(sqlmock.NewRows([]string{"ENGINE", "TABLE_ROWS"}).AddRow("MyISAM", 1200))
+	mockDB.ExpectExec(regexp.QuoteMeta("ALTER TABLE `oauth2_token` ENGINE=InnoDB")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// ACTION
+	err := store.ConvertEngine(context.Background(), "InnoDB")
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Len(t, logger.infos, 2)
+	assert.Equal(t, "converting table oauth2_token from MyISAM to InnoDB, about 1200 rows", logger.infos[0])
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestConvertEngine_ShouldBeNoopWhenEngineMatches(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT ENGINE, TABLE_ROWS FROM information_schema.TABLES")).
+		WillReturnRows(sqlmock.NewRows([]string{"ENGINE", "TABLE_ROWS"}).AddRow("InnoDB", 1200))
+
+	// ACTION
+	err := store.ConvertEngine(context.Background(), "innodb")
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestConvertEngine_ShouldRejectInvalidEngine(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+
+	// ACTION
+	err := store.ConvertEngine(context.Background(), "InnoDB; DROP TABLE x")
+
+	// ASSERT
+	assert.EqualError(t, err, `mysql: convert engine: invalid engine "InnoDB; DROP TABLE x"`)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestDescribeSchema_ShouldReturnLiveColumns(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("FROM information_schema.COLUMNS WHERE TABLE_SCHEMA=DATABASE() AND TABLE_NAME=? ORDER BY ORDINAL_POSITION")).
+		WithArgs("oauth2_token").
+		WillReturnRows(sqlmock.NewRows([]string{"COLUMN_NAME", "COLUMN_TYPE", "CHARACTER_MAXIMUM_LENGTH", "IS_NULLABLE"}).
+			AddRow("id", "bigint(20)", nil, "NO").
+			AddRow("code", "varchar(255)", 255, "YES"))
+
+	// ACTION
+	columns, err := store.DescribeSchema(context.Background())
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, "oauth2_token", store.TableName())
+	assert.Equal(t, []ColumnInfo{
+		{Name: "id", Type: "bigint(20)"},
+		{Name: "code", Type: "varchar(255)", Size: 255, Nullable: true},
+	}, columns)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWithSkipTableCreation_ShouldOnlyCheckTable(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM information_schema.TABLES WHERE TABLE_SCHEMA=DATABASE() AND TABLE_NAME=?")).
+		WithArgs("oauth2_token").
+		WillReturnRows(sqlmock.NewRows([]string{"count(*)"}).AddRow(1))
+	missingDB, missingMock, _ := sqlmock.New()
+	missingMock.ExpectQuery(regexp.QuoteMeta("FROM information_schema.TABLES")).
+		WillReturnRows(sqlmock.NewRows([]string{"count(*)"}).AddRow(0))
+
+	// ACTION
+	store, err := NewStoreWithOptsE(db, WithSkipTableCreation(true), WithGCTimeInterval(-1))
+	_, missingErr := NewStoreWithOptsE(missingDB, WithSkipTableCreation(true), WithGCTimeInterval(-1))
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.NotNil(t, store)
+	assert.EqualError(t, missingErr, "mysql: check table: table oauth2_token does not exist")
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, missingMock.ExpectationsWereMet())
+}
+
+func TestPoolSizes_ShouldNormalizeAndWarn(t *testing.T) {
+	for _, tc := range []struct {
+		maxOpen, maxIdle int
+		open, idle       int
+		warnings         []string
+	}{
+		{50, 25, 50, 25, nil},
+		{0, 25, 0, 25, nil},
+		{10, 30, 10, 10, []string{"MaxIdleConns 30 exceeds MaxOpenConns 10, using 10"}},
+		{-1, -2, 0, 0, []string{
+			"MaxOpenConns -1 is negative, using 0 (unlimited)",
+			"MaxIdleConns -2 is negative, using 0 (no idle connections)",
+		}},
+	} {
+		// ARRANGE
+		config := NewConfig(dsn)
+		config.MaxOpenConns, config.MaxIdleConns = tc.maxOpen, tc.maxIdle
+
+		// ACTION
+		open, idle, warnings := config.poolSizes()
+
+		// ASSERT
+		assert.Equal(t, tc.open, open)
+		assert.Equal(t, tc.idle, idle)
+		assert.Equal(t, tc.warnings, warnings)
+	}
+}
+
+func TestSetPool_ShouldCloseConnectionsIdleForMaxIdleTime(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+	defer db.Close()
+	mockDB.ExpectClose()
+	config := NewConfig(dsn)
+	config.MaxIdleTime = 10 * time.Millisecond
+	config.setPool(db)
+
+	// ACTION
+	err := db.Ping()
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return db.Stats().MaxIdleTimeClosed == 1
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestNewStoreWithDBs_ShouldSplitReadsAndWrites(t *testing.T) {
+	// ARRANGE
+	writeDB, writeMock, _ := sqlmock.New()
+	readDB, readMock, _ := sqlmock.New()
+	writeMock.ExpectExec(regexp.QuoteMeta("create table if not exists")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDefaultIndexes(writeMock)
+
+	store, err := NewStoreWithDBsE(writeDB, readDB, "", -1)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	writeMock.ExpectExec(regexp.QuoteMeta("insert into `oauth2_token`")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	readMock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE access=? AND expired_at>? LIMIT 1")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "expired_at", "code", "access", "refresh", "data", "user_id"}).
+			AddRow(1, time.Now().Add(time.Hour).Unix(), "", "1_1_1", "", `{"Access":"1_1_1"}`, ""))
+	writeMock.ExpectExec(regexp.QuoteMeta("UPDATE `oauth2_token` SET access=''")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// ACTION
+	ctx := context.Background()
+	assert.NoError(t, store.Create(ctx, &models.Token{Access: "1_1_1", AccessCreateAt: time.Now(), AccessExpiresIn: time.Hour}))
+	info, err := store.GetByAccess(ctx, "1_1_1")
+	assert.NoError(t, err)
+	assert.NoError(t, store.RemoveByAccess(ctx, "1_1_1"))
+
+	// ASSERT
+	assert.Equal(t, "1_1_1", info.GetAccess())
+	assert.NoError(t, writeMock.ExpectationsWereMet())
+	assert.NoError(t, readMock.ExpectationsWereMet())
+}
+
+func TestReadFromPrimary_ShouldReadFromWriteDB(t *testing.T) {
+	// ARRANGE
+	writeDB, writeMock, _ := sqlmock.New()
+	readDB, readMock, _ := sqlmock.New()
+	writeMock.ExpectExec(regexp.QuoteMeta("create table if not exists")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDefaultIndexes(writeMock)
+
+	store, err := NewStoreWithDBsE(writeDB, readDB, "", -1)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	writeMock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE code=? AND expired_at>? LIMIT 1")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "expired_at", "code", "access", "refresh", "data", "user_id"}).
+			AddRow(1, time.Now().Add(time.Hour).Unix(), "11_11_11", "", "", `{"Code":"11_11_11"}`, ""))
+
+	// ACTION
+	info, err := store.GetByCode(ReadFromPrimary(context.Background()), "11_11_11")
+
+	// ASSERT
+	assert.NoError(t, err)
+	if assert.NotNil(t, info) {
+		assert.Equal(t, "11_11_11", info.GetCode())
+	}
+	assert.NoError(t, writeMock.ExpectationsWereMet())
+	assert.NoError(t, readMock.ExpectationsWereMet())
+}
+
+func TestNewStoreWithDBs_ShouldFallBackToWriteDB(t *testing.T) {
+	// ARRANGE
+	writeDB, writeMock, _ := sqlmock.New()
+	writeMock.ExpectExec(regexp.QuoteMeta("create table if not exists")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDefaultIndexes(writeMock)
+
+	// ACTION
+	store, err := NewStoreWithDBsE(writeDB, nil, "", -1)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	// ASSERT
+	assert.Same(t, store.db, store.readDB)
+}
+
+func TestGetByAccess_ShouldRetryTransientErrors(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithRetry(2, time.Millisecond))
+	defer store.Close()
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE access=? AND expired_at>? LIMIT 1")).
+		WillReturnError(mysqldriver.ErrInvalidConn)
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE access=? AND expired_at>? LIMIT 1")).
+		WillReturnError(&net.OpError{Op: "read", Err: syscall.ECONNRESET})
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE access=? AND expired_at>? LIMIT 1")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "expired_at", "code", "access", "refresh", "data", "user_id"}).
+			AddRow(1, time.Now().Add(time.Hour).Unix(), "", "1_1_1", "", `{"Access":"1_1_1"}`, ""))
+
+	// ACTION
+	info, err := store.GetByAccess(context.Background(), "1_1_1")
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, "1_1_1", info.GetAccess())
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestGetByAccess_ShouldGiveUpAfterMaxRetries(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithRetry(1, time.Millisecond))
+	defer store.Close()
+
+	for i := 0; i < 2; i++ {
+		mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE access=? AND expired_at>? LIMIT 1")).
+			WillReturnError(mysqldriver.ErrInvalidConn)
+	}
+
+	// ACTION
+	_, err := store.GetByAccess(context.Background(), "1_1_1")
+
+	// ASSERT
+	assert.Equal(t, mysqldriver.ErrInvalidConn, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestCreate_ShouldNotRetryDuplicateKey(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithRetry(3, time.Millisecond))
+	defer store.Close()
+
+	dupErr := &mysqldriver.MySQLError{Number: 1062, Message: "Duplicate entry"}
+	mockDB.ExpectExec(regexp.QuoteMeta("insert into `oauth2_token`")).
+		WillReturnError(dupErr)
+
+	// ACTION
+	err := store.Create(context.Background(), &models.Token{Access: "1_1_1", AccessCreateAt: time.Now(), AccessExpiresIn: time.Hour})
+
+	// ASSERT
+	assert.Equal(t, dupErr, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestNewStoreWithOptsE_ShouldRetryStartupPing(t *testing.T) {
+	// ARRANGE
+	db, mockDB, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockDB.ExpectPing().WillReturnError(mysqldriver.ErrInvalidConn)
+	mockDB.ExpectPing()
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDefaultIndexes(mockDB)
+
+	// ACTION
+	store, err := NewStoreWithOptsE(db, WithGCTimeInterval(-1), WithStartupPing(3, time.Millisecond))
+
+	// ASSERT
+	assert.NoError(t, err)
+	defer store.Close()
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestNewStoreWithOptsE_ShouldFailWhenStartupPingNeverSucceeds(t *testing.T) {
+	// ARRANGE
+	db, mockDB, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i := 0; i < 2; i++ {
+		mockDB.ExpectPing().WillReturnError(mysqldriver.ErrInvalidConn)
+	}
+
+	// ACTION
+	_, err = NewStoreWithOptsE(db, WithGCTimeInterval(-1), WithStartupPing(2, time.Millisecond))
+
+	// ASSERT
+	assert.ErrorIs(t, err, mysqldriver.ErrInvalidConn)
+	assert.Contains(t, err.Error(), "after 2 attempts")
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestList_ShouldReturnPageAndTotal(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM `oauth2_token`")).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` ORDER BY id LIMIT ? OFFSET ?")).
+		WithArgs(2, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "expired_at", "code", "access", "refresh", "data", "user_id"}).
+			AddRow(2, 0, "", "2_2_2", "", `{"Access":"2_2_2","UserID":"u1"}`, "u1").
+			AddRow(3, 0, "", "3_3_3", "", `{"Access":"3_3_3","UserID":"u2"}`, "u2"))
+
+	// ACTION
+	items, total, err := store.List(context.Background(), 1, 2)
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), total)
+	if assert.Len(t, items, 2) {
+		assert.Equal(t, int64(2), items[0].ID)
+		assert.Equal(t, "3_3_3", items[1].Access)
+	}
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestListTokens_ShouldApplyFilter(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM `oauth2_token`")).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` ORDER BY id LIMIT ? OFFSET ?")).
+		WithArgs(10, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "expired_at", "code", "access", "refresh", "data", "user_id"}).
+			AddRow(1, 0, "", "1_1_1", "", `{"Access":"1_1_1","UserID":"u1"}`, "u1").
+			AddRow(2, 0, "", "2_2_2", "", `{"Access":"2_2_2","UserID":"u2"}`, "u2"))
+
+	// ACTION
+	infos, total, err := store.ListTokens(context.Background(), 0, 10, func(info oauth2.TokenInfo) bool {
+		return info.GetUserID() == "u2"
+	})
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	if assert.Len(t, infos, 1) {
+		assert.Equal(t, "2_2_2", infos[0].GetAccess())
+	}
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestGetByAccess_ShouldRejectCorruptRows(t *testing.T) {
+	for _, data := range []string{`{"Access":`, `{}`} {
+		// ARRANGE
+		logger := &recordLogger{}
+		store, mockDB := newMockStore(t, WithLogger(logger))
+		mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE access=?")).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "expired_at", "code", "access", "refresh", "data", "user_id"}).
+				AddRow(7, time.Now().Add(time.Hour).Unix(), "", "1_1_1", "", data, ""))
+
+		// ACTION
+		info, err := store.GetByAccess(context.Background(), "1_1_1")
+		store.Close()
+
+		// ASSERT
+		assert.Nil(t, info)
+		assert.ErrorIs(t, err, ErrCorruptToken)
+		assert.Contains(t, err.Error(), "row 7")
+		if assert.Len(t, logger.lines, 1) {
+			assert.Contains(t, logger.lines[0], "token row 7")
+		}
+		assert.NoError(t, mockDB.ExpectationsWereMet())
+	}
+}
+
+func TestListTokens_ShouldSkipCorruptRows(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithSkipCorruptRows(true), WithLogger(nil))
+	defer store.Close()
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM `oauth2_token`")).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` ORDER BY id LIMIT ? OFFSET ?")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "expired_at", "code", "access", "refresh", "data", "user_id"}).
+			AddRow(1, 0, "", "1_1_1", "", `not json`, "").
+			AddRow(2, 0, "", "2_2_2", "", `{"Access":"2_2_2"}`, ""))
+
+	// ACTION
+	infos, _, err := store.ListTokens(context.Background(), 0, 10, nil)
+
+	// ASSERT
+	assert.NoError(t, err)
+	if assert.Len(t, infos, 1) {
+		assert.Equal(t, "2_2_2", infos[0].GetAccess())
+	}
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestForEach_ShouldStreamRowsInIDOrder(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT id, expired_at, code, access, refresh, data, user_id FROM `oauth2_token` ORDER BY id")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "expired_at", "code", "access", "refresh", "data", "user_id"}).
+			AddRow(1, 10, nil, "1_1_1", "", `{"Access":"1_1_1"}`, "u1").
+			AddRow(2, 20, "", "2_2_2", nil, `{"Access":"2_2_2"}`, "u2"))
+	var items []StoreItem
+
+	// ACTION
+	err := store.ForEach(context.Background(), func(item *StoreItem) error {
+		items = append(items, *item)
+		return nil
+	})
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, []StoreItem{
+		{ID: 1, ExpiredAt: 10, Access: "1_1_1", Data: `{"Access":"1_1_1"}`, UserID: "u1"},
+		{ID: 2, ExpiredAt: 20, Access: "2_2_2", Data: `{"Access":"2_2_2"}`, UserID: "u2"},
+	}, items)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestForEach_ShouldStopAtCallbackError(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithTenant("t1"))
+	defer store.Close()
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT id, expired_at, code, access, refresh, data, user_id, tenant_id FROM `oauth2_token` WHERE tenant_id=? ORDER BY id")).
+		WithArgs("t1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "expired_at", "code", "access", "refresh", "data", "user_id", "tenant_id"}).
+			AddRow(1, 10, "", "1_1_1", "", "", "", "t1").
+			AddRow(2, 20, "", "2_2_2", "", "", "", "t1"))
+	stop := errors.New("stop")
+	var ids []int64
+
+	// ACTION
+	err := store.ForEach(context.Background(), func(item *StoreItem) error {
+		ids = append(ids, item.ID)
+		return stop
+	})
+
+	// ASSERT
+	assert.Equal(t, stop, err)
+	assert.Equal(t, []int64{1}, ids)
+}
+
+func TestRemoveByUserID_ShouldDeleteEveryRowOfUser(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token` WHERE user_id=?") + "$").
+		WithArgs("1_1").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	// ACTION
+	n, err := store.RemoveByUserID(context.Background(), "1_1")
+	_, clientErr := store.RemoveByClientID(context.Background(), "1")
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	assert.Error(t, clientErr)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWithClientIDColumn_ShouldStoreAndRemoveByClientID(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+	mockDB.ExpectExec(regexp.QuoteMeta("`user_id` varchar(16), `client_id` varchar(128)")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDefaultIndexes(mockDB)
+	mockDB.ExpectExec(regexp.QuoteMeta("create index idx_client_id on `oauth2_token` (`client_id`)")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	store := NewStoreWithOpts(db, WithClientIDColumn(true), WithGCTimeInterval(-1))
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("insert into `oauth2_token` (`id`,`expired_at`,`code`,`access`,`refresh`,`data`,`user_id`,`client_id`)")).
+		WithArgs(sqlmock.AnyArg(), "", "1_1_1", "", sqlmock.AnyArg(), "1_1", "1").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token` WHERE client_id=?")).
+		WithArgs("1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// ACTION
+	err := store.Create(context.Background(), &models.Token{ClientID: "1", UserID: "1_1", Access: "1_1_1", AccessCreateAt: time.Now(), AccessExpiresIn: time.Hour})
+	n, removeErr := store.RemoveByClientID(context.Background(), "1")
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.NoError(t, removeErr)
+	assert.Equal(t, int64(1), n)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestRemoveByCode_ShouldReturnNilWhenRowRemoved(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithNotFoundError(true))
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE `oauth2_token` SET code='' WHERE code=?")).
+		WithArgs("11_11_11").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// ACTION
+	err := store.RemoveByCode(context.Background(), "11_11_11")
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestRemoveByCode_ShouldReturnErrNotFoundWhenNothingMatched(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithNotFoundError(true))
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE `oauth2_token` SET code='' WHERE code=?")).
+		WithArgs("11_11_11").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// ACTION
+	err := store.RemoveByCode(context.Background(), "11_11_11")
+
+	// ASSERT
+	assert.Equal(t, ErrNotFound, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestRemoveByCode_ShouldIgnoreMissingRowByDefault(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE `oauth2_token` SET code='' WHERE code=?")).
+		WithArgs("11_11_11").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// ACTION
+	err := store.RemoveByCode(context.Background(), "11_11_11")
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestRemoveExpiredBefore_ShouldDeleteRowsExpiredBeforeTime(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithGCBatchSize(2))
+	defer store.Close()
+
+	before := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, n := range []int64{2, 1} {
+		mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token` WHERE expired_at<=? ORDER BY expired_at LIMIT ?")).
+			WithArgs(before.Unix(), 2).
+			WillReturnResult(sqlmock.NewResult(0, n))
+	}
+
+	// ACTION
+	n, err := store.RemoveExpiredBefore(context.Background(), before)
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestNewStoreWithOpts_ShouldCreateTenantColumnAndIndexes(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists `oauth2_token` (`id` bigint not null primary key auto_increment, `expired_at` bigint, `code` varchar(255), `access` varchar(255), `refresh` varchar(255), `data` text, `user_id` varchar(16), `tenant_id` varchar(64))")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectExec(regexp.QuoteMeta("create index idx_access_expired on `oauth2_token` (`tenant_id`, `access`, `expired_at`) using btree;")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectExec(regexp.QuoteMeta("create index idx_tenant_user on `oauth2_token` (`user_id`, `tenant_id`) using btree;")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// ACTION
+	store := NewStoreWithOpts(db,
+		WithGCTimeInterval(-1),
+		WithTenant("t1"),
+		WithIndexes(
+			Index{Name: "idx_access_expired", Columns: []string{"access", "expired_at"}},
+			Index{Name: "idx_tenant_user", Columns: []string{"user_id", "tenant_id"}},
+		),
+	)
+	defer store.Close()
+
+	// ASSERT
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestNewStoreWithOpts_ShouldCreateJSONDataColumn(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists `oauth2_token` (`id` bigint not null primary key auto_increment, `expired_at` bigint, `code` varchar(255), `access` varchar(255), `refresh` varchar(255), `data` json, `user_id` varchar(16))")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDefaultIndexes(mockDB)
+
+	// ACTION
+	store, err := NewStoreWithOptsE(db, WithGCTimeInterval(-1), WithDataColumnType("json"))
+
+	// ASSERT
+	assert.NoError(t, err)
+	defer store.Close()
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestNewStoreWithOptsE_ShouldRejectInvalidDataColumnTypes(t *testing.T) {
+	tests := map[string][]Option{
+		"mysql: invalid data column type \"BLOB\"":                             {WithDataColumnType("blob")},
+		"mysql: a JSON data column can't store compressed or encrypted tokens": {WithDataColumnType(DataTypeJSON), WithCompression(true)},
+		"mysql: WithNullTokens and WithTypeConverter can't be combined":        {WithNullTokens(true), WithTypeConverter(nullStringConverter{})},
+	}
+	for want, opts := range tests {
+		// ARRANGE
+		db, mockDB, _ := sqlmock.New()
+
+		// ACTION
+		_, err := NewStoreWithOptsE(db, append([]Option{WithGCTimeInterval(-1)}, opts...)...)
+
+		// ASSERT
+		assert.EqualError(t, err, want)
+		assert.NoError(t, mockDB.ExpectationsWereMet())
+	}
+}
+
+func TestNewStoreWithOpts_ShouldAppendTableOptions(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+	mockDB.ExpectExec(regexp.QuoteMeta("`user_id` varchar(16)) engine=InnoDB charset=utf8mb4 ROW_FORMAT=COMPRESSED KEY_BLOCK_SIZE=8;")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDefaultIndexes(mockDB)
+
+	// ACTION
+	store, err := NewStoreWithOptsE(db, WithGCTimeInterval(-1), WithRowFormat("compressed"), WithKeyBlockSize(8))
+
+	// ASSERT
+	assert.NoError(t, err)
+	defer store.Close()
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestNewStoreWithOpts_ShouldAddTableConstraints(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+	mockDB.ExpectExec(regexp.QuoteMeta("`user_id` varchar(16), CHECK (expired_at > 1600000000)) engine=InnoDB charset=utf8mb4;")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDefaultIndexes(mockDB)
+	invalidDB, invalidMock, _ := sqlmock.New()
+
+	// ACTION
+	store, err := NewStoreWithOptsE(db, WithGCTimeInterval(-1), WithTableConstraints("CHECK (expired_at > 1600000000)"))
+	_, invalidErr := NewStoreWithOptsE(invalidDB, WithGCTimeInterval(-1), WithTableConstraints("CHECK (1); DROP TABLE x"))
+
+	// ASSERT
+	assert.NoError(t, err)
+	defer store.Close()
+	assert.EqualError(t, invalidErr, `mysql: invalid table constraint "CHECK (1); DROP TABLE x"`)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, invalidMock.ExpectationsWereMet())
+}
+
+func TestNewStoreWithOptsE_ShouldRejectInvalidTableOptions(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+
+	// ACTION
+	_, err := NewStoreWithOptsE(db, WithGCTimeInterval(-1), WithRowFormat("dynamic"), WithKeyBlockSize(3))
+
+	// ASSERT
+	assert.EqualError(t, err, "mysql: invalid key block size 3")
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+type upperConverter struct{}
+
+func (upperConverter) ToDb(val interface{}) (interface{}, error) {
+	if s, ok := val.(string); ok {
+		return strings.ToUpper(s), nil
+	}
+	return val, nil
+}
+
+func (upperConverter) FromDb(target interface{}) (gorp.CustomScanner, bool) {
+	return gorp.CustomScanner{}, false
+}
+
+func TestWithTypeConverter_ShouldConvertInsertedValues(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithTypeConverter(upperConverter{}))
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("insert into `oauth2_token`")).
+		WithArgs(sqlmock.AnyArg(), "", "ACCESS", "", sqlmock.AnyArg(), "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	// ACTION
+	err := store.Create(context.Background(), &models.Token{Access: "access", AccessCreateAt: time.Now(), AccessExpiresIn: time.Hour})
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWithTenant_ShouldScopeQueriesToTenant(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithTenant("t1"))
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("insert into `oauth2_token` (`id`,`expired_at`,`code`,`access`,`refresh`,`data`,`user_id`,`tenant_id`)")).
+		WithArgs(sqlmock.AnyArg(), "", "1_1_1", "", sqlmock.AnyArg(), "", "t1").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE access=? AND expired_at>? AND tenant_id=? LIMIT 1")).
+		WithArgs("1_1_1", sqlmock.AnyArg(), "t1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "expired_at", "code", "access", "refresh", "data", "user_id", "tenant_id"}))
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE `oauth2_token` SET access='' WHERE access=? AND tenant_id=?")).
+		WithArgs("1_1_1", "t1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token` WHERE (expired_at<=? OR (code='' AND access='' AND refresh='')) AND tenant_id=? ORDER BY expired_at LIMIT ?")).
+		WithArgs(sqlmock.AnyArg(), "t1", DefaultGCBatchSize).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM `oauth2_token` WHERE expired_at>? AND tenant_id=?")).
+		WithArgs(sqlmock.AnyArg(), "t1").
+		WillReturnRows(sqlmock.NewRows([]string{"count(*)"}).AddRow(0))
+
+	ctx := context.Background()
+
+	// ACTION
+	createErr := store.Create(ctx, &models.Token{Access: "1_1_1", AccessCreateAt: time.Now(), AccessExpiresIn: time.Hour})
+	info, getErr := store.GetByAccess(ctx, "1_1_1")
+	removeErr := store.RemoveByAccess(ctx, "1_1_1")
+	purged, purgeErr := store.PurgeExpired(ctx)
+	count, countErr := store.Count(ctx)
+
+	// ASSERT
+	assert.NoError(t, createErr)
+	assert.NoError(t, getErr)
+	assert.Nil(t, info)
+	assert.NoError(t, removeErr)
+	assert.NoError(t, purgeErr)
+	assert.Equal(t, int64(1), purged)
+	assert.NoError(t, countErr)
+	assert.Equal(t, int64(0), count)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestMigrate_ShouldAddTenantColumn(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithTenant("t1"))
+	defer store.Close()
+
+	columns := []string{"COLUMN_NAME", "DATA_TYPE", "CHARACTER_MAXIMUM_LENGTH", "ENGINE"}
+	mockDB.ExpectQuery(regexp.QuoteMeta("FROM information_schema.COLUMNS")).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("code", "varchar", 255, "InnoDB").
+			AddRow("access", "varchar", 255, "InnoDB").
+			AddRow("refresh", "varchar", 255, "InnoDB").
+			AddRow("data", "text", 65535, "InnoDB"))
+	mockDB.ExpectExec(regexp.QuoteMeta("ALTER TABLE `oauth2_token` ADD COLUMN `tenant_id` varchar(64) NOT NULL DEFAULT ''")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// ACTION
+	stmts, err := store.Migrate(context.Background())
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Len(t, stmts, 1)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestMigrate_ShouldAddClientIDColumn(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDefaultIndexes(mockDB)
+	mockDB.ExpectExec(regexp.QuoteMeta("create index idx_client_id on")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	store := NewStoreWithOpts(db, WithClientIDColumn(true), WithGCTimeInterval(-1))
+	defer store.Close()
+
+	columns := []string{"COLUMN_NAME", "DATA_TYPE", "CHARACTER_MAXIMUM_LENGTH", "ENGINE"}
+	mockDB.ExpectQuery(regexp.QuoteMeta("FROM information_schema.COLUMNS")).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("code", "varchar", 255, "InnoDB").
+			AddRow("access", "varchar", 255, "InnoDB").
+			AddRow("refresh", "varchar", 255, "InnoDB").
+			AddRow("data", "text", 65535, "InnoDB"))
+	mockDB.ExpectExec(regexp.QuoteMeta("ALTER TABLE `oauth2_token` ADD COLUMN `client_id` varchar(128)")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectExec(regexp.QuoteMeta("CREATE INDEX idx_client_id ON `oauth2_token` (`client_id`)")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// ACTION
+	stmts, err := store.Migrate(context.Background())
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Len(t, stmts, 2)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestMigrate_ShouldAddAccessExpiredAtColumn(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDefaultIndexes(mockDB)
+	mockDB.ExpectExec(regexp.QuoteMeta("create index idx_access_expired_at on")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	store := NewStoreWithOpts(db, WithSplitExpiry(true), WithGCTimeInterval(-1))
+	defer store.Close()
+
+	columns := []string{"COLUMN_NAME", "DATA_TYPE", "CHARACTER_MAXIMUM_LENGTH", "ENGINE"}
+	mockDB.ExpectQuery(regexp.QuoteMeta("FROM information_schema.COLUMNS")).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("code", "varchar", 255, "InnoDB").
+			AddRow("access", "varchar", 255, "InnoDB").
+			AddRow("refresh", "varchar", 255, "InnoDB").
+			AddRow("data", "text", 65535, "InnoDB"))
+	mockDB.ExpectExec(regexp.QuoteMeta("ALTER TABLE `oauth2_token` ADD COLUMN `access_expired_at` bigint NOT NULL DEFAULT 0")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE `oauth2_token` SET `access_expired_at`=`expired_at`")).
+		WillReturnResult(sqlmock.NewResult(0, 5))
+	mockDB.ExpectExec(regexp.QuoteMeta("CREATE INDEX idx_access_expired_at ON `oauth2_token` (`access_expired_at`)")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// ACTION
+	stmts, err := store.Migrate(context.Background())
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Len(t, stmts, 3)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestMigrate_ShouldAddDeletedAtColumn(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDefaultIndexes(mockDB)
+	mockDB.ExpectExec(regexp.QuoteMeta("create index idx_deleted_at on")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	store := NewStoreWithOpts(db, WithSoftDelete(30*24*time.Hour), WithGCTimeInterval(-1))
+	defer store.Close()
+
+	columns := []string{"COLUMN_NAME", "DATA_TYPE", "CHARACTER_MAXIMUM_LENGTH", "ENGINE"}
+	mockDB.ExpectQuery(regexp.QuoteMeta("FROM information_schema.COLUMNS")).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("code", "varchar", 255, "InnoDB").
+			AddRow("access", "varchar", 255, "InnoDB").
+			AddRow("refresh", "varchar", 255, "InnoDB").
+			AddRow("data", "text", 65535, "InnoDB"))
+	mockDB.ExpectExec(regexp.QuoteMeta("ALTER TABLE `oauth2_token` ADD COLUMN `deleted_at` bigint NOT NULL DEFAULT 0")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectExec(regexp.QuoteMeta("CREATE INDEX idx_deleted_at ON `oauth2_token` (`deleted_at`)")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// ACTION
+	stmts, err := store.Migrate(context.Background())
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Len(t, stmts, 2)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestPurgeExpired_ShouldClearExpiredAccessWithSplitExpiry(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDefaultIndexes(mockDB)
+	mockDB.ExpectExec(regexp.QuoteMeta("create index idx_access_expired_at on")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	store := NewStoreWithOpts(db, WithSplitExpiry(true), WithGCTimeInterval(-1))
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token` WHERE expired_at<=?")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE `oauth2_token` SET access='' WHERE access_expired_at<=? AND expired_at>? AND NOT (access='') ORDER BY access_expired_at LIMIT ?")).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), DefaultGCBatchSize).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	// ACTION
+	n, err := store.PurgeExpired(context.Background())
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+type chanMetrics chan error
+
+func (m chanMetrics) ObserveGC(deleted int64, duration time.Duration, err error) {
+	m <- err
+}
+
+func TestGC_ShouldKeepRunningAfterTransientError(t *testing.T) {
+	// ARRANGE
+	cycles := make(chanMetrics, 3)
+	db, mockDB, _ := sqlmock.New()
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDefaultIndexes(mockDB)
+
+	// the gc goroutine starts with the store, expect its queries first
+	gcErr := errors.New("connection reset")
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token`")).
+		WillReturnError(gcErr)
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token`")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	store := NewStoreWithOpts(db, WithGCInterval(time.Millisecond*10), WithMetrics(cycles), WithLogger(nil))
+
+	// ACTION
+	var errs []error
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-cycles:
+			errs = append(errs, err)
+		case <-time.After(time.Second):
+			t.Fatal("gc cycle did not run")
+		}
+	}
+
+	store.Close()
+
+	// ASSERT
+	assert.Equal(t, []error{gcErr, nil}, errs)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestSetGCInterval_ShouldStartAndPauseGC(t *testing.T) {
+	// ARRANGE
+	cycles := make(chanMetrics, 10)
+	store, mockDB := newMockStore(t, WithMetrics(cycles), WithLogger(nil))
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token`")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// ACTION
+	store.SetGCInterval(time.Millisecond * 10)
+	select {
+	case <-cycles:
+	case <-time.After(time.Second):
+		t.Fatal("gc cycle did not run")
+	}
+	store.SetGCInterval(-1)
+	// drain a cycle that may have started before the pause
+	time.Sleep(time.Millisecond * 30)
+	for len(cycles) > 0 {
+		<-cycles
+	}
+
+	// ASSERT
+	select {
+	case <-cycles:
+		t.Fatal("gc kept running after the pause")
+	case <-time.After(time.Millisecond * 50):
+	}
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestSetGCInterval_ShouldBeSafeWhileGCRuns(t *testing.T) {
+	// ARRANGE
+	store, _ := newMockStore(t, WithLogger(nil))
+	store.SetGCInterval(time.Millisecond)
+
+	// ACTION
+	for i := 1; i <= 20; i++ {
+		store.SetGCInterval(time.Millisecond * time.Duration(i%3))
+		time.Sleep(time.Millisecond)
+	}
+	store.Close()
+
+	// ASSERT
+	assert.NotPanics(t, func() { store.SetGCInterval(time.Second) })
+}
+
+func TestUpdate_ShouldUpdateMatchingRowInPlace(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT id FROM `oauth2_token` WHERE access=? LIMIT 1 FOR UPDATE")).
+		WithArgs("1_1_1").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+	mockDB.ExpectExec(regexp.QuoteMeta("update `oauth2_token` set `expired_at`=?, `code`=?, `access`=?, `refresh`=?, `data`=?, `user_id`=? where `id`=?;")).
+		WithArgs(sqlmock.AnyArg(), "", "1_1_1", "2_2_2", sqlmock.AnyArg(), "", 42).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectCommit()
+
+	// ACTION
+	err := store.Update(context.Background(), &models.Token{
+		Access:           "1_1_1",
+		AccessCreateAt:   time.Now(),
+		AccessExpiresIn:  time.Hour,
+		Refresh:          "2_2_2",
+		RefreshCreateAt:  time.Now(),
+		RefreshExpiresIn: time.Hour * 24,
+	})
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestUpdate_ShouldInsertWhenNoRowMatches(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT id FROM `oauth2_token` WHERE code=? LIMIT 1 FOR UPDATE")).
+		WithArgs("11_11_11").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mockDB.ExpectExec(regexp.QuoteMeta("insert into `oauth2_token`")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectCommit()
+
+	// ACTION
+	err := store.Update(context.Background(), &models.Token{Code: "11_11_11", CodeCreateAt: time.Now(), CodeExpiresIn: time.Minute})
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestUpdate_ShouldRollbackOnError(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT id FROM `oauth2_token` WHERE access=? LIMIT 1 FOR UPDATE")).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+	mockDB.ExpectExec(regexp.QuoteMeta("update `oauth2_token`")).
+		WillReturnError(errors.New("lock wait timeout"))
+	mockDB.ExpectRollback()
+
+	// ACTION
+	err := store.Update(context.Background(), &models.Token{Access: "1_1_1", AccessCreateAt: time.Now(), AccessExpiresIn: time.Hour})
+
+	// ASSERT
+	assert.EqualError(t, err, "lock wait timeout")
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestNewStoreWithOpts_ShouldCreateUniqueTokenIndexes(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectExec(regexp.QuoteMeta("create index idx_expired_at on `oauth2_token` (`expired_at`) using btree;")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, column := range []string{"code", "access", "refresh"} {
+		mockDB.ExpectExec(regexp.QuoteMeta("create unique index uniq_" + column + " on `oauth2_token` ((nullif(`" + column + "`, ''))) using btree;")).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+
+	// ACTION
+	store := NewStoreWithOpts(db,
+		WithGCTimeInterval(-1),
+		WithIndexes(Index{Name: "idx_expired_at", Columns: []string{"expired_at"}}),
+		WithUniqueTokens(true),
+	)
+	defer store.Close()
+
+	// ASSERT
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestNewStoreWithOptsE_ShouldRejectUniqueIndexOnTextColumn(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDefaultIndexes(mockDB)
+
+	// ACTION
+	_, err := NewStoreWithOptsE(db,
+		WithGCTimeInterval(-1),
+		WithColumnSizes(ColumnSizes{Code: 1024}),
+		WithUniqueTokens(true),
+	)
+
+	// ASSERT
+	assert.EqualError(t, err, "mysql: create index uniq_code: unique index on TEXT column code")
+}
+
+func TestWithNullTokens_ShouldStoreRemovedTokensAsNull(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithNullTokens(true))
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("insert into `oauth2_token`")).
+		WithArgs(sqlmock.AnyArg(), nil, "1_1_1", nil, sqlmock.AnyArg(), nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE access=? AND expired_at>? LIMIT 1")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "expired_at", "code", "access", "refresh", "data", "user_id"}).
+			AddRow(1, time.Now().Add(time.Hour).Unix(), nil, "1_1_1", nil, `{"Access":"1_1_1"}`, nil))
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE `oauth2_token` SET access=NULL WHERE access=?")).
+		WithArgs("1_1_1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token`")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	ctx := context.Background()
+
+	// ACTION
+	createErr := store.Create(ctx, &models.Token{Access: "1_1_1", AccessCreateAt: time.Now(), AccessExpiresIn: time.Hour})
+	info, getErr := store.GetByAccess(ctx, "1_1_1")
+	removeErr := store.RemoveByAccess(ctx, "1_1_1")
+	_, purgeErr := store.PurgeExpired(ctx)
+
+	// ASSERT
+	assert.NoError(t, createErr)
+	if assert.NoError(t, getErr) {
+		assert.Equal(t, "1_1_1", info.GetAccess())
+	}
+	assert.NoError(t, removeErr)
+	assert.NoError(t, purgeErr)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWithNullTokens_ShouldCreatePlainUniqueIndexes(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectExec(regexp.QuoteMeta("create index idx_expired_at on")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, column := range []string{"code", "access", "refresh"} {
+		mockDB.ExpectExec(regexp.QuoteMeta("create unique index uniq_" + column + " on `oauth2_token` (`" + column + "`) using btree;")).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+
+	// ACTION
+	store := NewStoreWithOpts(db,
+		WithGCTimeInterval(-1),
+		WithIndexes(Index{Name: "idx_expired_at", Columns: []string{"expired_at"}}),
+		WithUniqueTokens(true),
+		WithNullTokens(true),
+	)
+	defer store.Close()
+
+	// ASSERT
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestExistsByAccess_ShouldReportUnexpiredToken(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT 1 FROM `oauth2_token` WHERE access=? AND expired_at>? LIMIT 1")).
+		WithArgs("1_1_1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(1))
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT 1 FROM `oauth2_token` WHERE access=? AND expired_at>? LIMIT 1")).
+		WithArgs("2_2_2", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"1"}))
+
+	ctx := context.Background()
+
+	// ACTION
+	found, foundErr := store.ExistsByAccess(ctx, "1_1_1")
+	missing, missingErr := store.ExistsByAccess(ctx, "2_2_2")
+	empty, emptyErr := store.ExistsByAccess(ctx, "")
+
+	// ASSERT
+	assert.NoError(t, foundErr)
+	assert.True(t, found)
+	assert.NoError(t, missingErr)
+	assert.False(t, missing)
+	assert.NoError(t, emptyErr)
+	assert.False(t, empty)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestCreate_ShouldRejectExpiredToken(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+
+	// ACTION
+	zeroErr := store.Create(context.Background(), &models.Token{Access: "1_1_1", AccessExpiresIn: time.Hour})
+	pastErr := store.Create(context.Background(), &models.Token{
+		Code:          "11_11_11",
+		CodeCreateAt:  time.Now().Add(-time.Hour),
+		CodeExpiresIn: time.Minute,
+	})
+	staleErr := store.Create(context.Background(), &models.Token{
+		Access:           "2_2_2",
+		AccessCreateAt:   time.Now().Add(-2 * time.Hour),
+		AccessExpiresIn:  time.Hour,
+		Refresh:          "3_3_3",
+		RefreshCreateAt:  time.Now().Add(-2 * time.Hour),
+		RefreshExpiresIn: time.Hour,
+	})
+
+	// ASSERT
+	assert.True(t, errors.Is(zeroErr, ErrTokenExpired))
+	assert.EqualError(t, zeroErr, "mysql: token already expired: expired at 0001-01-01T01:00:00Z")
+	assert.True(t, errors.Is(pastErr, ErrTokenExpired))
+	assert.True(t, errors.Is(staleErr, ErrTokenExpired))
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestCreate_ShouldStoreTokensWithoutLifetimeAsNeverExpiring(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("insert into `oauth2_token`")).
+		WithArgs(neverExpires, "", "1_1_1", "2_2_2", sqlmock.AnyArg(), "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectExec(regexp.QuoteMeta("insert into `oauth2_token`")).
+		WithArgs(neverExpires, "", "3_3_3", "", sqlmock.AnyArg(), "").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+
+	// ACTION
+	refreshErr := store.Create(context.Background(), &models.Token{
+		Access:          "1_1_1",
+		AccessCreateAt:  time.Now(),
+		AccessExpiresIn: time.Hour,
+		Refresh:         "2_2_2",
+		RefreshCreateAt: time.Now(),
+	})
+	accessErr := store.Create(context.Background(), &models.Token{
+		Access:         "3_3_3",
+		AccessCreateAt: time.Now().Add(-time.Hour),
+	})
+
+	// ASSERT
+	assert.NoError(t, refreshErr)
+	assert.NoError(t, accessErr)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWithNowFunc_ShouldDecideExpiry(t *testing.T) {
+	// ARRANGE
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	store, mockDB := newMockStore(t, WithNowFunc(func() time.Time { return now }))
+	defer store.Close()
+
+	token := &models.Token{Access: "1_1_1", AccessCreateAt: now.Add(-time.Hour), AccessExpiresIn: time.Hour}
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token`")).
+		WithArgs(now.Unix(), DefaultGCBatchSize).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// ACTION
+	atExpiryErr := store.Create(context.Background(), token)
+	now = now.Add(-time.Second)
+	beforeExpiry, err := store.newItem(token)
+	assert.NoError(t, err)
+	now = now.Add(time.Second)
+	n, purgeErr := store.PurgeExpired(context.Background())
+
+	// ASSERT
+	assert.True(t, errors.Is(atExpiryErr, ErrTokenExpired))
+	assert.Equal(t, now.Unix(), beforeExpiry.ExpiredAt)
+	assert.NoError(t, purgeErr)
+	assert.Equal(t, int64(1), n)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestGetByAccess_ShouldSkipExpiredRows(t *testing.T) {
+	// ARRANGE
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	store, mockDB := newMockStore(t, WithNowFunc(func() time.Time { return now }))
+	defer store.Close()
+	readExpired, readMock := newMockStore(t, WithReadExpired(true))
+	defer readExpired.Close()
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE access=? AND expired_at>? LIMIT 1")).
+		WithArgs("1_1_1", now.Unix()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "expired_at", "code", "access", "refresh", "data", "user_id"}))
+	readMock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE access=? LIMIT 1")).
+		WithArgs("1_1_1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "expired_at", "code", "access", "refresh", "data", "user_id"}).
+			AddRow(1, now.Unix(), "", "1_1_1", "", `{"Access":"1_1_1"}`, ""))
+
+	// ACTION
+	info, err := store.GetByAccess(context.Background(), "1_1_1")
+	assert.NoError(t, err)
+	expired, expiredErr := readExpired.GetByAccess(context.Background(), "1_1_1")
+
+	// ASSERT
+	assert.Nil(t, info)
+	assert.NoError(t, expiredErr)
+	if assert.NotNil(t, expired) {
+		assert.Equal(t, "1_1_1", expired.GetAccess())
+	}
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.NoError(t, readMock.ExpectationsWereMet())
+}
+
+func TestCreateIfAbsent_ShouldReportWhetherRowWasInserted(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+
+	info := &models.Token{UserID: "1_1", Access: "1_1_1", AccessCreateAt: time.Now(), AccessExpiresIn: time.Hour}
+	query := "INSERT INTO `oauth2_token` (`expired_at`,`code`,`access`,`refresh`,`data`,`user_id`) VALUES (?,?,?,?,?,?) ON DUPLICATE KEY UPDATE id=id"
+	mockDB.ExpectExec(regexp.QuoteMeta(query)).
+		WithArgs(sqlmock.AnyArg(), "", "1_1_1", "", sqlmock.AnyArg(), "1_1").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectExec(regexp.QuoteMeta(query)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// ACTION
+	first, firstErr := store.CreateIfAbsent(context.Background(), info)
+	second, secondErr := store.CreateIfAbsent(context.Background(), info)
+
+	// ASSERT
+	assert.NoError(t, firstErr)
+	assert.True(t, first)
+	assert.NoError(t, secondErr)
+	assert.False(t, second)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestCreateBatch_ShouldInsertInChunks(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithInsertBatchSize(2))
+	defer store.Close()
+
+	infos := make([]oauth2.TokenInfo, 3)
+	for i := range infos {
+		infos[i] = &models.Token{Access: fmt.Sprintf("%d_%d_%d", i, i, i), AccessCreateAt: time.Now(), AccessExpiresIn: time.Hour}
+	}
+
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO `oauth2_token` (`expired_at`,`code`,`access`,`refresh`,`data`,`user_id`) VALUES (?,?,?,?,?,?),(?,?,?,?,?,?)")).
+		WithArgs(sqlmock.AnyArg(), "", "0_0_0", "", sqlmock.AnyArg(), "",
+			sqlmock.AnyArg(), "", "1_1_1", "", sqlmock.AnyArg(), "").
+		WillReturnResult(sqlmock.NewResult(1, 2))
+	mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO `oauth2_token` (`expired_at`,`code`,`access`,`refresh`,`data`,`user_id`) VALUES (?,?,?,?,?,?)")).
+		WithArgs(sqlmock.AnyArg(), "", "2_2_2", "", sqlmock.AnyArg(), "").
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	mockDB.ExpectCommit()
+
+	// ACTION
+	err := store.CreateBatch(context.Background(), infos)
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestCreateBatch_ShouldRollbackOnError(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithInsertBatchSize(1))
+	defer store.Close()
+
+	infos := []oauth2.TokenInfo{
+		&models.Token{Access: "1_1_1", AccessCreateAt: time.Now(), AccessExpiresIn: time.Hour},
+		&models.Token{Access: "2_2_2", AccessCreateAt: time.Now(), AccessExpiresIn: time.Hour},
+	}
+
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO `oauth2_token`")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO `oauth2_token`")).
+		WillReturnError(errors.New("duplicate entry"))
+	mockDB.ExpectRollback()
+
+	// ACTION
+	err := store.CreateBatch(context.Background(), infos)
+
+	// ASSERT
+	assert.EqualError(t, err, "duplicate entry")
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+// newGCMockStore creates a store whose gc runs every few milliseconds,
+// with the gc queries expected before the gc goroutine starts
+func newGCMockStore(t *testing.T, expect func(mockDB sqlmock.Sqlmock)) (*Store, sqlmock.Sqlmock) {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDefaultIndexes(mockDB)
+	expect(mockDB)
+	return NewStoreWithOpts(db, WithGCInterval(time.Millisecond*5), WithLogger(nil)), mockDB
+}
+
+// lockedBuffer buffer safe to write from the gc goroutine while the test
+// reads it
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestSetStdout_ShouldNotRaceWithGC(t *testing.T) {
+	// ARRANGE
+	store, _ := newGCMockStore(t, func(mockDB sqlmock.Sqlmock) {})
+	defer store.Close()
+
+	// ACTION, the failing gc cycles log while the output changes
+	store.SetGCInterval(time.Millisecond)
+	last := &lockedBuffer{}
+	deadline := time.Now().Add(50 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		store.SetStdout(&lockedBuffer{})
+		time.Sleep(100 * time.Microsecond)
+	}
+	store.SetStdout(last)
+
+	// ASSERT
+	assert.Eventually(t, func() bool {
+		return strings.Contains(last.String(), "[OAUTH2-MYSQL-ERROR]: ")
+	}, time.Second, time.Millisecond, "the gc errors go to the last writer")
+}
+
+func TestCloseContext_ShouldWaitForGCCycle(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newGCMockStore(t, func(mockDB sqlmock.Sqlmock) {
+		mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token`")).
+			WillDelayFor(time.Millisecond * 100).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mockDB.ExpectClose()
+	})
+	time.Sleep(time.Millisecond * 30)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// ACTION
+	err := store.CloseContext(ctx)
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestCloseContext_ShouldReturnContextErrorOnDeadline(t *testing.T) {
+	// ARRANGE
+	store, _ := newGCMockStore(t, func(mockDB sqlmock.Sqlmock) {
+		mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token`")).
+			WillDelayFor(time.Second * 10).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mockDB.ExpectClose()
+	})
+	time.Sleep(time.Millisecond * 30)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+
+	// ACTION
+	start := time.Now()
+	err := store.CloseContext(ctx)
+
+	// ASSERT
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	assert.NoError(t, store.CloseContext(context.Background()))
+}
+
+func TestClose_ShouldNotLogCancelledGCCycle(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDefaultIndexes(mockDB)
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token`")).
+		WillDelayFor(time.Second * 10).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	logger := &recordLogger{}
+	store := NewStoreWithOpts(db, WithGCInterval(time.Millisecond*5), WithLogger(logger))
+	time.Sleep(time.Millisecond * 30)
+
+	// ACTION
+	store.Close()
+	store.gcRunning.Wait()
+
+	// ASSERT
+	assert.Empty(t, logger.lines)
+}
+
+func TestNextGCInterval_ShouldStayWithinJitter(t *testing.T) {
+	// ARRANGE
+	store, _ := newMockStore(t, WithGCJitter(time.Second))
+	store.gcInterval = time.Minute
+	store.gcRand = rand.New(rand.NewSource(1))
+	seen := make(map[time.Duration]bool)
+
+	// ACTION
+	for i := 0; i < 100; i++ {
+		seen[store.nextGCInterval()] = true
+	}
+
+	// ASSERT
+	for interval := range seen {
+		if interval < time.Minute || interval >= time.Minute+time.Second {
+			t.Fatalf("interval %s outside of [1m, 1m1s)", interval)
+		}
+	}
+	if len(seen) < 2 {
+		t.Errorf("expected jittered intervals, got %v", seen)
+	}
+}
+
+func TestNextGCInterval_ShouldBeFixedWithoutJitter(t *testing.T) {
+	// ARRANGE
+	store, _ := newMockStore(t)
+	store.gcInterval = time.Minute
+
+	// ACTION
+	interval := store.nextGCInterval()
+
+	// ASSERT
+	if interval != time.Minute {
+		t.Errorf("expected 1m, got %s", interval)
+	}
+}
@@ -0,0 +1,50 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+
+	"gopkg.in/gorp.v2"
+)
+
+// nullStringConverter stores empty strings as NULL and reads NULL back
+// as the empty string, used by WithNullTokens
+type nullStringConverter struct{}
+
+func (nullStringConverter) ToDb(val interface{}) (interface{}, error) {
+	if s, ok := val.(string); ok && s == "" {
+		return nil, nil
+	}
+	return val, nil
+}
+
+func (nullStringConverter) FromDb(target interface{}) (gorp.CustomScanner, bool) {
+	if _, ok := target.(*string); !ok {
+		return gorp.CustomScanner{}, false
+	}
+	binder := func(holder, target interface{}) error {
+		s, ok := holder.(*sql.NullString)
+		if !ok {
+			return errors.New("mysql: unexpected null string holder")
+		}
+		*target.(*string) = s.String
+		return nil
+	}
+	return gorp.CustomScanner{Holder: new(sql.NullString), Target: target, Binder: binder}, true
+}
+
+// emptyToken returns the sql value of a removed token column
+func (s *Store) emptyToken() string {
+	if s.nullTokens {
+		return "NULL"
+	}
+	return "''"
+}
+
+// isEmptyToken returns the condition matching a removed token column
+func (s *Store) isEmptyToken(column string) string {
+	if s.nullTokens {
+		return column + " IS NULL"
+	}
+	return column + "=''"
+}
@@ -1,8 +1,13 @@
 package mysql
 
 import (
+	"database/sql"
+	"io"
+	"strings"
 	"time"
 
+	"github.com/go-oauth2/oauth2/v4"
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/gorp.v2"
 )
 
@@ -16,7 +21,8 @@ func (f optionFunc) apply(store *Store) {
 	f(store)
 }
 
-// WithTableName sets the table name for the store.
+// WithTableName sets the table name for the store, an empty name keeps
+// DefaultTableName.
 func WithTableName(tableName string) Option {
 	return optionFunc(func(store *Store) {
 		if tableName != "" {
@@ -32,11 +38,552 @@ func WithSQLDialect(dialect gorp.MySQLDialect) Option {
 	})
 }
 
-// WithGCTimeInterval sets the time interval for garbage collection.
+// WithDialect sets the sql dialect for the store, such as
+// gorp.SqliteDialect to run the store on SQLite in tests.
+// Other dialects than MySQL get portable statements.
+func WithDialect(dialect gorp.Dialect) Option {
+	return optionFunc(func(store *Store) {
+		store.db.Dialect = dialect
+	})
+}
+
+// WithGCTimeInterval sets the time interval (in seconds) for garbage collection.
+// A negative interval disables the background garbage collection.
 func WithGCTimeInterval(interval int) Option {
 	return optionFunc(func(store *Store) {
 		if interval != 0 {
-			store.ticker = time.NewTicker(time.Second * time.Duration(interval))
+			store.gcInterval = time.Second * time.Duration(interval)
+		}
+	})
+}
+
+// WithGCInterval sets the time interval for garbage collection.
+// A negative interval disables the background garbage collection.
+func WithGCInterval(interval time.Duration) Option {
+	return optionFunc(func(store *Store) {
+		if interval != 0 {
+			store.gcInterval = interval
+		}
+	})
+}
+
+// WithGCJitter adds a random delay of up to jitter to every gc interval,
+// so replicas started together spread their deletes over time.
+func WithGCJitter(jitter time.Duration) Option {
+	return optionFunc(func(store *Store) {
+		store.gcJitter = jitter
+	})
+}
+
+// WithGCDryRun makes the gc cycles count the rows they would delete and
+// log the count instead of deleting them, the metrics hook receives the
+// count as the number of deleted rows. See SetGCDryRun.
+func WithGCDryRun(dryRun bool) Option {
+	return optionFunc(func(store *Store) {
+		store.gcDryRun = dryRun
+	})
+}
+
+// WithGCMissingTable sets what the gc does when the token table does not
+// exist: keep retrying while logging it once (the default), pause, or
+// re-create the table, see MissingTableAction.
+func WithGCMissingTable(action MissingTableAction) Option {
+	return optionFunc(func(store *Store) {
+		store.gcMissingTable = action
+	})
+}
+
+// WithEngine sets the storage engine used when creating the table.
+func WithEngine(engine string) Option {
+	return optionFunc(func(store *Store) {
+		if dialect, ok := store.db.Dialect.(gorp.MySQLDialect); ok && engine != "" {
+			dialect.Engine = engine
+			store.db.Dialect = dialect
+		}
+	})
+}
+
+// WithCharset sets the character set used when creating the table.
+func WithCharset(charset string) Option {
+	return optionFunc(func(store *Store) {
+		if dialect, ok := store.db.Dialect.(gorp.MySQLDialect); ok && charset != "" {
+			dialect.Encoding = charset
+			store.db.Dialect = dialect
+		}
+	})
+}
+
+// WithRowFormat sets the InnoDB row format of the table, such as DYNAMIC
+// or COMPRESSED. Like the engine and charset it only applies when the
+// store creates the table, an existing one needs an ALTER TABLE ...
+// ROW_FORMAT=... run by hand.
+func WithRowFormat(format string) Option {
+	return optionFunc(func(store *Store) {
+		store.rowFormat = strings.ToUpper(format)
+	})
+}
+
+// WithKeyBlockSize sets the page size in KB, 1 to 16, of a compressed
+// table, see WithRowFormat. It only applies when the store creates the
+// table, an existing one needs an ALTER TABLE ... KEY_BLOCK_SIZE=... run
+// by hand.
+func WithKeyBlockSize(size int) Option {
+	return optionFunc(func(store *Store) {
+		store.keyBlockSize = size
+	})
+}
+
+// WithTableConstraints adds table constraints, such as
+// CHECK (expired_at > 1600000000), to the CREATE TABLE statement, as a
+// database level guard against bad rows. The constraints are raw SQL
+// and must come from trusted configuration. CHECK constraints are
+// enforced from MySQL 8.0.16, older servers parse and ignore them, and
+// MySQL rejects non-deterministic functions such as UNIX_TIMESTAMP() in
+// them. Like the row format they only apply when the store creates the
+// table.
+func WithTableConstraints(constraints ...string) Option {
+	return optionFunc(func(store *Store) {
+		store.constraints = append(store.constraints, constraints...)
+	})
+}
+
+// WithStdout sets the error output of the store.
+func WithStdout(stdout io.Writer) Option {
+	return optionFunc(func(store *Store) {
+		store.SetStdout(stdout)
+	})
+}
+
+// WithLogger sets the logger used to report errors and, when it
+// implements InfoLogger or DebugLogger, progress, see WithLogLevel.
+func WithLogger(logger Logger) Option {
+	return optionFunc(func(store *Store) {
+		store.SetLogger(logger)
+	})
+}
+
+// WithLogLevel sets the minimum level of the lines the store logs, the
+// default LogLevelInfo reports gc cycles deleting rows and maintenance
+// progress, LogLevelDebug also reports the gc cycles deleting nothing and
+// LogLevelError only the errors. Info and debug lines require a logger
+// implementing InfoLogger and DebugLogger, as NewWriterLogger does.
+func WithLogLevel(level LogLevel) Option {
+	return optionFunc(func(store *Store) {
+		if level > 0 {
+			store.logLevel = level
+		}
+	})
+}
+
+// WithQueryLogger calls the hook after every statement the store runs,
+// with its arguments and duration, to debug slow queries without the
+// server general query log. String arguments, holding the tokens and
+// their data, are masked by the redactor unless WithUnsafeQueryArgs is
+// enabled.
+func WithQueryLogger(hook QueryLogger) Option {
+	return optionFunc(func(store *Store) {
+		store.queryLogger = hook
+	})
+}
+
+// WithAuditHooks sets the hooks reporting every token stored and
+// removed, for an audit trail, see AuditHooks.
+func WithAuditHooks(hooks AuditHooks) Option {
+	return optionFunc(func(store *Store) {
+		store.audit = hooks
+	})
+}
+
+// WithUnsafeQueryArgs passes the string arguments to the query logger as
+// is, tokens included. Only enable it on development databases.
+func WithUnsafeQueryArgs(unsafe bool) Option {
+	return optionFunc(func(store *Store) {
+		store.unsafeArgs = unsafe
+	})
+}
+
+// WithStatementCache prepares the lookups and removals by token on first
+// use and reuses the statements until the store is closed, sparing the
+// server the parsing of the hottest queries. database/sql prepares a
+// statement again on every new connection; a statement failing with a
+// connection error is also dropped and prepared again on the next call.
+// The statements run outside transactions only.
+func WithStatementCache(enabled bool) Option {
+	return optionFunc(func(store *Store) {
+		store.cacheStmts = enabled
+	})
+}
+
+// WithRedactor sets how the tokens are masked in the query logger
+// arguments and in the errors of the statements, such as a duplicate
+// entry quoting the token, nil keeps MaskRedactor. The errors are
+// redacted even with WithUnsafeQueryArgs.
+func WithRedactor(redactor Redactor) Option {
+	return optionFunc(func(store *Store) {
+		if redactor != nil {
+			store.redactor = redactor
+		}
+	})
+}
+
+// WithGCBatchSize sets the maximum number of rows deleted per gc statement.
+func WithGCBatchSize(size int) Option {
+	return optionFunc(func(store *Store) {
+		if size > 0 {
+			store.gcBatchSize = size
+		}
+	})
+}
+
+// WithGCUnordered drops the ORDER BY expired_at of the MySQL gc batches,
+// for servers or proxies that reject ORDER BY in a DELETE or UPDATE.
+// The ordered batches walk idx_expired_at from its start, unordered ones
+// may touch rows scattered over the table.
+func WithGCUnordered(unordered bool) Option {
+	return optionFunc(func(store *Store) {
+		store.gcUnordered = unordered
+	})
+}
+
+// WithGCResetAutoIncrement makes the gc count the rows after a cycle that
+// deleted some and reset the autoincrement once the table is empty, see
+// ResetAutoIncrement. It requires the MyISAM engine, which counts the
+// rows without a scan; InnoDB tables can call ResetAutoIncrement when
+// they see fit.
+func WithGCResetAutoIncrement(reset bool) Option {
+	return optionFunc(func(store *Store) {
+		store.gcResetAutoInc = reset
+	})
+}
+
+// WithMaxRows caps the number of token rows: after every cycle the gc
+// evicts the rows beyond the cap, those expiring first, and logs how many
+// it evicted. Evicted tokens are lost, access tokens are rejected and
+// refresh tokens can't be used anymore. Zero, the default, disables the
+// cap. A tenant scoped store caps the rows of its tenant.
+func WithMaxRows(rows int) Option {
+	return optionFunc(func(store *Store) {
+		store.maxRows = rows
+	})
+}
+
+// WithSkipCorruptRows makes ListTokens skip the rows whose data can't be
+// decoded, logging them, instead of failing with ErrCorruptToken, so a
+// maintenance job over the whole table is not stopped by a corrupt row.
+// The Get* methods always return the error.
+func WithSkipCorruptRows(skip bool) Option {
+	return optionFunc(func(store *Store) {
+		store.skipCorrupt = skip
+	})
+}
+
+// WithInsertBatchSize sets the maximum number of rows inserted per
+// CreateBatch statement.
+func WithInsertBatchSize(size int) Option {
+	return optionFunc(func(store *Store) {
+		if size > 0 {
+			store.insertBatchSize = size
+		}
+	})
+}
+
+// WithHardDelete makes the Remove* methods delete the matching row
+// instead of clearing its token column.
+func WithHardDelete(hardDelete bool) Option {
+	return optionFunc(func(store *Store) {
+		store.hardDelete = hardDelete
+	})
+}
+
+// WithSoftDelete makes the Remove* methods revoke the matching row by
+// setting its deleted_at column to the current time, for an audit trail
+// of the revoked tokens. Like with WithHardDelete the whole row is
+// revoked, whichever of its tokens is removed. The Get*, Exists* and
+// Touch lookups skip revoked rows, the GetItemBy* methods still return
+// them. The gc deletes a revoked row once the retention since its
+// revocation ended, expired rows that were never revoked are deleted as
+// usual. RemoveExpiredBefore, PurgeRemoved and the WithMaxRows cap leave
+// the revoked rows within their retention alone too. A non positive retention disables soft deletes. On an existing
+// table Migrate adds the deleted_at column.
+func WithSoftDelete(retention time.Duration) Option {
+	return optionFunc(func(store *Store) {
+		store.softDelete = retention > 0
+		store.softRetention = retention
+	})
+}
+
+// WithReadExpired makes the Get* lookups return tokens that expired but
+// were not deleted by the gc yet, for example to log them. By default
+// such tokens are treated as not found. The GetItem* lookups always
+// return the raw row.
+func WithReadExpired(readExpired bool) Option {
+	return optionFunc(func(store *Store) {
+		store.readExpired = readExpired
+	})
+}
+
+// WithUUIDKeys keys the token table by random UUIDs generated by the store
+// before every insert instead of an autoincrement id, see UUIDStoreItem.
+// The returned StoreItem rows carry the key in UUID, GetByID and
+// GetItemByID don't match any row. The key type only applies when the
+// table is created.
+func WithUUIDKeys(uuidKeys bool) Option {
+	return optionFunc(func(store *Store) {
+		store.uuidKeys = uuidKeys
+	})
+}
+
+// WithSkipTableCreation assumes the token table and its indexes already
+// exist, created by migrations, so the store works with a database user
+// lacking DDL privileges. On MySQL the store fails to start when the
+// table is missing.
+func WithSkipTableCreation(skip bool) Option {
+	return optionFunc(func(store *Store) {
+		store.skipCreate = skip
+	})
+}
+
+// WithClientIDColumn also stores the client id of every token in an
+// indexed client_id column, for RemoveByClientID. Migrate adds the
+// column and its index to an existing table.
+func WithClientIDColumn(clientIDColumn bool) Option {
+	return optionFunc(func(store *Store) {
+		store.clientIDColumn = clientIDColumn
+	})
+}
+
+// WithSplitExpiry stores the access token expiry in its own indexed
+// access_expired_at column, expired_at staying the expiry of the row
+// (the refresh token one when there is a refresh token). The gc then
+// clears the access token of a row once it expires, keeping the row while
+// the refresh token is valid, and deletes the row once both expired.
+// GetByAccess filters on access_expired_at. On an existing table Migrate
+// adds the column, set to expired_at for the existing rows, and its index.
+func WithSplitExpiry(splitExpiry bool) Option {
+	return optionFunc(func(store *Store) {
+		store.splitExpiry = splitExpiry
+	})
+}
+
+// WithTenant scopes the store to a tenant sharing the table with others:
+// Create stores the tenant id in the tenant_id column and every lookup,
+// removal and gc statement only matches the tenant's rows. The column is
+// created and prefixed to the indexes when the store creates the table,
+// Migrate adds it to an existing one. An empty id leaves the store unscoped.
+func WithTenant(tenantID string) Option {
+	return optionFunc(func(store *Store) {
+		store.tenantID = tenantID
+	})
+}
+
+// WithNotFoundError makes the Remove* methods return ErrNotFound
+// when no row matched the token, instead of nil.
+func WithNotFoundError(enabled bool) Option {
+	return optionFunc(func(store *Store) {
+		store.notFoundError = enabled
+	})
+}
+
+// WithTokenNotFoundError makes GetByCode, GetByAccess, GetByRefresh and
+// GetByID return (nil, ErrTokenNotFound) when no live token matched,
+// instead of the (nil, nil) the oauth2 TokenStore convention expects.
+// Keep it disabled for stores used by the oauth2 manager, which reports
+// the error as is rather than as an invalid token; GetItemBy* keep
+// returning nil either way.
+func WithTokenNotFoundError(enabled bool) Option {
+	return optionFunc(func(store *Store) {
+		store.tokenNotFound = enabled
+	})
+}
+
+// WithMetrics sets the hook observing every gc cycle, and every store
+// operation when it implements QueryMetrics.
+func WithMetrics(metrics Metrics) Option {
+	return optionFunc(func(store *Store) {
+		store.metrics = metrics
+	})
+}
+
+// WithTokenFactory sets the function allocating the token information
+// the stored data is decoded into, it must return a pointer.
+// The default is models.NewToken.
+func WithTokenFactory(factory func() oauth2.TokenInfo) Option {
+	return optionFunc(func(store *Store) {
+		store.tokenFactory = factory
+	})
+}
+
+// WithUniqueTokens creates unique indexes on the code, access and refresh
+// columns in addition to the configured ones, so storing a token twice
+// fails instead of leaving GetBy* to pick one of the rows. Removed tokens
+// keep the empty value, which the indexes skip: MySQL needs 8.0.13 or later
+// for it and the columns must be VARCHAR. Creating the indexes fails on
+// a table already holding duplicates.
+func WithUniqueTokens(enabled bool) Option {
+	return optionFunc(func(store *Store) {
+		store.uniqueTokens = enabled
+	})
+}
+
+// WithNullTokens stores empty strings as NULL, so removed and absent
+// tokens are NULL rather than empty and plain unique indexes can be used on
+// the token columns, see WithUniqueTokens. Switching an existing table
+// over takes a migration of the rows written before, such as
+//
+//	UPDATE oauth2_token SET code=NULL WHERE code='';
+//	UPDATE oauth2_token SET access=NULL WHERE access='';
+//	UPDATE oauth2_token SET refresh=NULL WHERE refresh='';
+//
+// until then the gc does not recognize their removed tokens.
+func WithNullTokens(enabled bool) Option {
+	return optionFunc(func(store *Store) {
+		store.nullTokens = enabled
+	})
+}
+
+// WithDataColumnType sets the SQL type of the data column when the store
+// creates the table: DataTypeText, DataTypeJSON or DataTypeVarchar, the
+// latter sized by ColumnSizes.Data. Empty keeps the gorp mapping of the
+// data size, TEXT for the default size. A JSON column lets MySQL query
+// the token claims, it requires the default JSON codec and can't be
+// combined with compression or a cipher. Migrate does not change the
+// type of an existing column.
+func WithDataColumnType(dataType string) Option {
+	return optionFunc(func(store *Store) {
+		store.dataType = strings.ToUpper(dataType)
+	})
+}
+
+// WithTypeConverter sets the gorp converter of the column values, such as
+// one mapping Data to and from a JSON column. It replaces the converter
+// of WithNullTokens, the two can't be combined.
+func WithTypeConverter(converter gorp.TypeConverter) Option {
+	return optionFunc(func(store *Store) {
+		store.typeConverter = converter
+	})
+}
+
+// WithIndexes sets the indexes created on the token table,
+// replacing DefaultIndexes.
+func WithIndexes(indexes ...Index) Option {
+	return optionFunc(func(store *Store) {
+		if len(indexes) > 0 {
+			store.indexes = indexes
+		}
+	})
+}
+
+// WithCipher sets the cipher encrypting the token data at rest,
+// the ciphertext is stored base64 encoded.
+func WithCipher(cipher Cipher) Option {
+	return optionFunc(func(store *Store) {
+		store.cipher = cipher
+	})
+}
+
+// WithNowFunc sets the clock deciding which tokens have expired,
+// for the expiry checks of Create, Count, the Get* and Exists* lookups
+// and the gc.
+// It defaults to time.Now, tests can inject a fixed or fake clock.
+func WithNowFunc(now func() time.Time) Option {
+	return optionFunc(func(store *Store) {
+		if now != nil {
+			store.now = now
+		}
+	})
+}
+
+// WithCodec sets the codec serializing the token information,
+// json-iterator is used by default. Nil keeps the default.
+func WithCodec(codec Codec) Option {
+	return optionFunc(func(store *Store) {
+		if codec != nil {
+			store.codec = codec
+		}
+	})
+}
+
+// WithCompression gzip compresses the token data before it is stored,
+// so large tokens fit the Data column. The compressed data is stored
+// base64 encoded, rows written without compression remain readable.
+func WithCompression(compress bool) Option {
+	return optionFunc(func(store *Store) {
+		store.compress = compress
+	})
+}
+
+// WithColumnSizes sets the sizes of the token table columns,
+// zero sizes keep their default.
+func WithColumnSizes(sizes ColumnSizes) Option {
+	return optionFunc(func(store *Store) {
+		if sizes.Code > 0 {
+			store.sizes.Code = sizes.Code
+		}
+		if sizes.Access > 0 {
+			store.sizes.Access = sizes.Access
+		}
+		if sizes.Refresh > 0 {
+			store.sizes.Refresh = sizes.Refresh
+		}
+		if sizes.Data > 0 {
+			store.sizes.Data = sizes.Data
+		}
+	})
+}
+
+// WithReadDB sets a separate (replica) database used by the Get* and Count
+// lookups, writes and garbage collection keep using the store's database.
+func WithReadDB(db *sql.DB) Option {
+	return optionFunc(func(store *Store) {
+		if db != nil {
+			store.readDB = &gorp.DbMap{Db: db}
+		}
+	})
+}
+
+// WithQueryTimeout bounds every Create, CreateBatch, Update, Get*,
+// Exists*, Remove* and PurgeExpired call, including its retries, so a
+// hung connection cannot block the caller forever. The earlier of the
+// timeout and the deadline of the caller's context applies. A gc cycle
+// cut short by the timeout resumes on the next tick. Zero, the default,
+// disables the timeout.
+func WithQueryTimeout(timeout time.Duration) Option {
+	return optionFunc(func(store *Store) {
+		store.queryTimeout = timeout
+	})
+}
+
+// WithRetry retries Create and the Get* lookups up to maxRetries times
+// when they fail on a broken connection, waiting backoff before the first
+// retry and doubling it for each following one.
+func WithRetry(maxRetries int, backoff time.Duration) Option {
+	return optionFunc(func(store *Store) {
+		if maxRetries > 0 {
+			store.maxRetries = maxRetries
+		}
+		if backoff > 0 {
+			store.retryBackoff = backoff
+		}
+	})
+}
+
+// WithStartupPing pings the database before the store creates its table,
+// up to attempts times, waiting backoff after the first failure and
+// doubling it after each following one. The constructors returning an
+// error report a database still unreachable after the last attempt.
+func WithStartupPing(attempts int, backoff time.Duration) Option {
+	return optionFunc(func(store *Store) {
+		store.pingAttempts = attempts
+		if backoff > 0 {
+			store.pingBackoff = backoff
 		}
 	})
 }
+
+// WithTracer sets the tracer used to start a span around the Create,
+// Update, Get*, Remove* and PurgeExpired operations, tracing is off by default.
+func WithTracer(tracer trace.Tracer) Option {
+	return optionFunc(func(store *Store) {
+		store.tracer = tracer
+	})
+}
@@ -0,0 +1,133 @@
+// Package prometheus exposes the token store metrics to Prometheus.
+// It lives in its own package so the store does not depend on the
+// Prometheus client.
+package prometheus
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/codebeautiful/mysql/v4"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultCountTimeout default timeout of the token count run on every scrape
+const DefaultCountTimeout = time.Second * 5
+
+// Collector prometheus collector of the token store metrics. It observes
+// the gc and the store operations as the store's metrics hook and reads
+// the token count and connection pool statistics of the attached store
+// on every scrape:
+//
+//	collector := prometheus.NewCollector()
+//	store := mysql.NewStoreWithOptions(dsn, mysql.WithMetrics(collector))
+//	collector.Attach(store)
+//	registry.MustRegister(collector)
+type Collector struct {
+	// CountTimeout timeout of the token count (default 5s)
+	CountTimeout time.Duration
+
+	mu    sync.RWMutex
+	store *mysql.Store
+
+	tokens        *prom.Desc
+	openConns     *prom.Desc
+	inUseConns    *prom.Desc
+	idleConns     *prom.Desc
+	waitCount     *prom.Desc
+	gcDeleted     prom.Counter
+	gcErrors      prom.Counter
+	queryDuration *prom.HistogramVec
+}
+
+// NewCollector create prometheus collector instance
+func NewCollector() *Collector {
+	return &Collector{
+		CountTimeout: DefaultCountTimeout,
+		tokens: prom.NewDesc("oauth2_tokens_total",
+			"Number of token rows that have not expired yet.", nil, nil),
+		openConns: prom.NewDesc("oauth2_db_open_connections",
+			"Number of established connections to the database.", nil, nil),
+		inUseConns: prom.NewDesc("oauth2_db_in_use_connections",
+			"Number of connections currently in use.", nil, nil),
+		idleConns: prom.NewDesc("oauth2_db_idle_connections",
+			"Number of idle connections.", nil, nil),
+		waitCount: prom.NewDesc("oauth2_db_wait_count_total",
+			"Number of connections waited for.", nil, nil),
+		gcDeleted: prom.NewCounter(prom.CounterOpts{
+			Name: "oauth2_gc_deleted_total",
+			Help: "Number of token rows deleted by the gc.",
+		}),
+		gcErrors: prom.NewCounter(prom.CounterOpts{
+			Name: "oauth2_gc_errors_total",
+			Help: "Number of failed gc cycles.",
+		}),
+		queryDuration: prom.NewHistogramVec(prom.HistogramOpts{
+			Name:    "oauth2_query_duration_seconds",
+			Help:    "Duration of the token store operations.",
+			Buckets: prom.DefBuckets,
+		}, []string{"operation"}),
+	}
+}
+
+// Attach sets the store whose token count and connection pool
+// statistics are collected
+func (c *Collector) Attach(store *mysql.Store) {
+	c.mu.Lock()
+	c.store = store
+	c.mu.Unlock()
+}
+
+// ObserveGC implements mysql.Metrics
+func (c *Collector) ObserveGC(deleted int64, duration time.Duration, err error) {
+	c.gcDeleted.Add(float64(deleted))
+	if err != nil {
+		c.gcErrors.Inc()
+	}
+}
+
+// ObserveQuery implements mysql.QueryMetrics
+func (c *Collector) ObserveQuery(operation string, duration time.Duration, err error) {
+	c.queryDuration.WithLabelValues(operation).Observe(duration.Seconds())
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prom.Desc) {
+	ch <- c.tokens
+	ch <- c.openConns
+	ch <- c.inUseConns
+	ch <- c.idleConns
+	ch <- c.waitCount
+	c.gcDeleted.Describe(ch)
+	c.gcErrors.Describe(ch)
+	c.queryDuration.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prom.Metric) {
+	c.gcDeleted.Collect(ch)
+	c.gcErrors.Collect(ch)
+	c.queryDuration.Collect(ch)
+
+	c.mu.RLock()
+	store := c.store
+	c.mu.RUnlock()
+	if store == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.CountTimeout)
+	defer cancel()
+	if n, err := store.Count(ctx); err != nil {
+		ch <- prom.NewInvalidMetric(c.tokens, err)
+	} else {
+		ch <- prom.MustNewConstMetric(c.tokens, prom.GaugeValue, float64(n))
+	}
+
+	stats := store.Stats()
+	ch <- prom.MustNewConstMetric(c.openConns, prom.GaugeValue, float64(stats.OpenConnections))
+	ch <- prom.MustNewConstMetric(c.inUseConns, prom.GaugeValue, float64(stats.InUse))
+	ch <- prom.MustNewConstMetric(c.idleConns, prom.GaugeValue, float64(stats.Idle))
+	ch <- prom.MustNewConstMetric(c.waitCount, prom.CounterValue, float64(stats.WaitCount))
+}
@@ -0,0 +1,66 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/codebeautiful/mysql/v4"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMockStore(t *testing.T, collector *Collector) (*mysql.Store, sqlmock.Sqlmock) {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, index := range mysql.DefaultIndexes() {
+		mockDB.ExpectExec(regexp.QuoteMeta("create index " + index.Name + " on")).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	store := mysql.NewStoreWithOpts(db, mysql.WithGCTimeInterval(-1), mysql.WithMetrics(collector))
+	collector.Attach(store)
+	return store, mockDB
+}
+
+func TestCollector_ShouldCollectStoreMetrics(t *testing.T) {
+	// ARRANGE
+	collector := NewCollector()
+	store, mockDB := newMockStore(t, collector)
+	defer store.Close()
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE access=? AND expired_at>? LIMIT 1")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "expired_at", "code", "access", "refresh", "data", "user_id"}))
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token`")).
+		WillReturnError(errors.New("connection reset"))
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM `oauth2_token` WHERE expired_at>?")).
+		WillReturnRows(sqlmock.NewRows([]string{"count(*)"}).AddRow(7))
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM `oauth2_token` WHERE expired_at>?")).
+		WillReturnRows(sqlmock.NewRows([]string{"count(*)"}).AddRow(7))
+
+	// ACTION
+	_, _ = store.GetByAccess(context.Background(), "1_1_1")
+	_, err := store.PurgeExpired(context.Background())
+	collector.ObserveGC(0, 0, err)
+
+	// ASSERT
+	expected := `
+# HELP oauth2_gc_errors_total Number of failed gc cycles.
+# TYPE oauth2_gc_errors_total counter
+oauth2_gc_errors_total 1
+# HELP oauth2_tokens_total Number of token rows that have not expired yet.
+# TYPE oauth2_tokens_total gauge
+oauth2_tokens_total 7
+`
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"oauth2_gc_errors_total", "oauth2_tokens_total"))
+	// one series per operation: GetByAccess and PurgeExpired
+	assert.Equal(t, 2, testutil.CollectAndCount(collector, "oauth2_query_duration_seconds"))
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
@@ -0,0 +1,123 @@
+package mysql
+
+import "fmt"
+
+// queries statements of the gc and of the token lookups and removals,
+// built once from the table name, dialect and options of the store,
+// which never change once it is created. Every table name interpolation
+// of these statements happens in buildQueries.
+type queries struct {
+	// getToken and getItem select a row by token column (and id),
+	// getToken skipping the expired ones unless the store reads them
+	getToken map[string]string
+	getItem  map[string]string
+	// remove clears or deletes a row by token column
+	remove map[string]string
+	// purge and clearAccess run in batches, their last argument
+	// is the batch size
+	purge          string
+	countPurgeable string
+	clearAccess    string
+	countAccess    string
+	// touch extends the expiry of the row of an access token, touched
+	// checks the row it matches exists for MySQL reporting it unchanged
+	touch   string
+	touched string
+	// countRows and evict enforce the WithMaxRows cap, evict
+	// deleting the number of rows of its only argument
+	countRows string
+	evict     string
+}
+
+// lookupColumns columns the Get* and Remove* methods look rows up by
+var lookupColumns = []string{"id", "code", "access", "refresh"}
+
+// buildQueries builds the statements of the store
+func (s *Store) buildQueries() {
+	q := queries{
+		getToken: make(map[string]string, len(lookupColumns)),
+		getItem:  make(map[string]string, len(lookupColumns)),
+		remove:   make(map[string]string, len(lookupColumns)),
+	}
+	for _, column := range lookupColumns {
+		where, _ := s.scope(column + "=?")
+		q.getItem[column] = fmt.Sprintf("SELECT * FROM %s WHERE %s LIMIT 1", s.table(), where)
+		live := column + "=?"
+		if !s.readExpired {
+			expiry := "expired_at"
+			if column == "access" && s.splitExpiry {
+				expiry = "access_expired_at"
+			}
+			live += " AND " + expiry + ">?"
+		}
+		live, _ = s.scope(s.notDeleted(live))
+		q.getToken[column] = fmt.Sprintf("SELECT * FROM %s WHERE %s LIMIT 1", s.table(), live)
+		switch {
+		case s.hardDelete:
+			q.remove[column] = fmt.Sprintf("DELETE FROM %s WHERE %s", s.table(), where)
+		case s.softDelete:
+			// revoke the whole row, keeping its tokens for the audit trail
+			revoke, _ := s.scope(s.notDeleted(column + "=?"))
+			q.remove[column] = fmt.Sprintf("UPDATE %s SET deleted_at=? WHERE %s", s.table(), revoke)
+		default:
+			q.remove[column] = fmt.Sprintf("UPDATE %s SET %s=%s WHERE %s", s.table(), column, s.emptyToken(), where)
+		}
+	}
+
+	// the batches delete in expiry order, walking idx_expired_at
+	// sequentially rather than reading pages all over the table
+	purgeOrder, accessOrder := " ORDER BY expired_at", " ORDER BY access_expired_at"
+	if s.gcUnordered {
+		purgeOrder, accessOrder = "", ""
+	}
+	purgeCond := s.purgeCond()
+	if s.softDelete {
+		purgeCond = s.softPurgeCond(purgeCond)
+	}
+	purge, _ := s.scope(purgeCond)
+	q.purge = fmt.Sprintf("DELETE FROM %s WHERE %s%s LIMIT ?", s.table(), purge, purgeOrder)
+	if !s.isMySQL() {
+		q.purge = fmt.Sprintf("DELETE FROM %s WHERE id IN (SELECT id FROM %s WHERE %s LIMIT ?)", s.table(), s.table(), purge)
+	}
+	q.countPurgeable = fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", s.table(), purge)
+
+	access, _ := s.scope(s.notDeleted(s.expiredAccessCond()))
+	q.clearAccess = fmt.Sprintf("UPDATE %s SET access=%s WHERE %s%s LIMIT ?", s.table(), s.emptyToken(), access, accessOrder)
+	if !s.isMySQL() {
+		q.clearAccess = fmt.Sprintf("UPDATE %s SET access=%s WHERE id IN (SELECT id FROM %s WHERE %s LIMIT ?)", s.table(), s.emptyToken(), s.table(), access)
+	}
+	q.countAccess = fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", s.table(), access)
+
+	// never shorten the row of a longer lived refresh token
+	set := "expired_at=CASE WHEN expired_at<? THEN ? ELSE expired_at END"
+	if s.splitExpiry {
+		set = "access_expired_at=?, " + set
+	}
+	expiry := "expired_at"
+	if s.splitExpiry {
+		expiry = "access_expired_at"
+	}
+	touch, _ := s.scope(s.notDeleted("access=? AND " + expiry + ">?"))
+	q.touch = fmt.Sprintf("UPDATE %s SET %s WHERE %s", s.table(), set, touch)
+	q.touched = fmt.Sprintf("SELECT 1 FROM %s WHERE %s LIMIT 1", s.table(), touch)
+
+	var tenant string
+	if s.tenantID != "" {
+		tenant = " WHERE tenant_id=?"
+	}
+	q.countRows = fmt.Sprintf("SELECT COUNT(*) FROM %s%s", s.table(), tenant)
+	// the cap never evicts a revoked row within its retention
+	evictable := tenant
+	if s.softDelete {
+		evictable += " WHERE "
+		if s.tenantID != "" {
+			evictable = tenant + " AND "
+		}
+		evictable += "(deleted_at=0 OR deleted_at<=?)"
+	}
+	q.evict = fmt.Sprintf("DELETE FROM %s%s ORDER BY expired_at, id LIMIT ?", s.table(), evictable)
+	if !s.isMySQL() {
+		q.evict = fmt.Sprintf("DELETE FROM %s WHERE id IN (SELECT id FROM %s%s ORDER BY expired_at, id LIMIT ?)", s.table(), s.table(), evictable)
+	}
+	s.queries = q
+}
@@ -0,0 +1,130 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"gopkg.in/gorp.v2"
+)
+
+// QueryLogger hook called after every statement the store runs, see
+// WithQueryLogger. Statements gorp generates, the row inserts and
+// updates, are reported as "gorp insert" or "gorp update" and the table
+// name, without arguments.
+type QueryLogger func(ctx context.Context, query string, args []interface{}, duration time.Duration, err error)
+
+// contexter binds a gorp database or transaction to a context
+type contexter interface {
+	WithContext(ctx context.Context) gorp.SqlExecutor
+}
+
+// conn returns the executor of c bound to ctx, redacting the tokens out
+// of its errors and reporting its statements to the query logger when
+// the store has one
+func (s *Store) conn(ctx context.Context, c contexter) gorp.SqlExecutor {
+	ex := c.WithContext(ctx)
+	if db, ok := c.(*gorp.DbMap); ok && s.stmts[db] != nil {
+		ex = &stmtExecutor{SqlExecutor: ex, dbmap: ex.(*gorp.DbMap), ctx: ctx, cache: s.stmts[db]}
+	}
+	return &loggedExecutor{SqlExecutor: ex, ctx: ctx, store: s}
+}
+
+// loggedExecutor redacts and reports the statements of the executor it
+// wraps
+type loggedExecutor struct {
+	gorp.SqlExecutor
+	ctx   context.Context
+	store *Store
+}
+
+// log reports the statement and returns its error with the string
+// arguments, or the secrets of the rows, masked
+func (e *loggedExecutor) log(start time.Time, query string, args []interface{}, secrets []string, err error) error {
+	if secrets == nil {
+		secrets = stringArgs(args)
+	}
+	err = e.store.redactErr(err, secrets...)
+	if e.store.queryLogger == nil {
+		return err
+	}
+	if !e.store.unsafeArgs && len(args) > 0 {
+		redacted := make([]interface{}, len(args))
+		for i, arg := range args {
+			if value, ok := arg.(string); ok {
+				arg = e.store.redactor(value)
+			}
+			redacted[i] = arg
+		}
+		args = redacted
+	}
+	e.store.queryLogger(e.ctx, query, args, time.Since(start), err)
+	return err
+}
+
+func (e *loggedExecutor) WithContext(ctx context.Context) gorp.SqlExecutor {
+	return &loggedExecutor{SqlExecutor: e.SqlExecutor.WithContext(ctx), ctx: ctx, store: e.store}
+}
+
+func (e *loggedExecutor) Insert(list ...interface{}) error {
+	start := time.Now()
+	err := e.SqlExecutor.Insert(list...)
+	return e.log(start, "gorp insert "+e.store.tableName, nil, itemSecrets(list), err)
+}
+
+func (e *loggedExecutor) Update(list ...interface{}) (int64, error) {
+	start := time.Now()
+	n, err := e.SqlExecutor.Update(list...)
+	return n, e.log(start, "gorp update "+e.store.tableName, nil, itemSecrets(list), err)
+}
+
+func (e *loggedExecutor) Exec(query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	res, err := e.SqlExecutor.Exec(query, args...)
+	return res, e.log(start, query, args, nil, err)
+}
+
+func (e *loggedExecutor) Select(i interface{}, query string, args ...interface{}) ([]interface{}, error) {
+	start := time.Now()
+	rows, err := e.SqlExecutor.Select(i, query, args...)
+	return rows, e.log(start, query, args, nil, err)
+}
+
+func (e *loggedExecutor) SelectInt(query string, args ...interface{}) (int64, error) {
+	start := time.Now()
+	n, err := e.SqlExecutor.SelectInt(query, args...)
+	return n, e.log(start, query, args, nil, err)
+}
+
+func (e *loggedExecutor) SelectNullInt(query string, args ...interface{}) (sql.NullInt64, error) {
+	start := time.Now()
+	n, err := e.SqlExecutor.SelectNullInt(query, args...)
+	return n, e.log(start, query, args, nil, err)
+}
+
+func (e *loggedExecutor) SelectNullStr(query string, args ...interface{}) (sql.NullString, error) {
+	start := time.Now()
+	str, err := e.SqlExecutor.SelectNullStr(query, args...)
+	return str, e.log(start, query, args, nil, err)
+}
+
+func (e *loggedExecutor) SelectOne(holder interface{}, query string, args ...interface{}) error {
+	start := time.Now()
+	err := e.SqlExecutor.SelectOne(holder, query, args...)
+	return e.log(start, query, args, nil, err)
+}
+
+func (e *loggedExecutor) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := e.SqlExecutor.Query(query, args...)
+	return rows, e.log(start, query, args, nil, err)
+}
+
+// QueryRow reports the statement without its error, which only
+// surfaces when the row is scanned, unredacted
+func (e *loggedExecutor) QueryRow(query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := e.SqlExecutor.QueryRow(query, args...)
+	e.log(start, query, args, nil, nil)
+	return row
+}
@@ -0,0 +1,133 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-oauth2/oauth2/v4/models"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+type loggedQuery struct {
+	query string
+	args  []interface{}
+	err   error
+}
+
+func newQueryLoggedMockStore(t *testing.T, opts ...Option) (*Store, sqlmock.Sqlmock, *[]loggedQuery) {
+	var queries []loggedQuery
+	hook := func(ctx context.Context, query string, args []interface{}, duration time.Duration, err error) {
+		queries = append(queries, loggedQuery{query: query, args: args, err: err})
+	}
+	store, mockDB := newMockStore(t, append([]Option{WithQueryLogger(hook)}, opts...)...)
+	queries = nil
+	return store, mockDB, &queries
+}
+
+func TestQueryLogger_ShouldRedactStringArguments(t *testing.T) {
+	// ARRANGE
+	store, mockDB, queries := newQueryLoggedMockStore(t)
+	defer store.Close()
+
+	removeErr := errors.New("server has gone away")
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE `oauth2_token` SET access='' WHERE access=?")).
+		WithArgs("1_1_1").
+		WillReturnError(removeErr)
+
+	// ACTION
+	err := store.RemoveByAccess(context.Background(), "1_1_1")
+
+	// ASSERT
+	assert.Equal(t, removeErr, err)
+	assert.Equal(t, []loggedQuery{{
+		query: "UPDATE `oauth2_token` SET access='' WHERE access=?",
+		args:  []interface{}{"****_1_1"},
+		err:   removeErr,
+	}}, *queries)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestQueryLogger_ShouldPassArgumentsWhenUnsafe(t *testing.T) {
+	// ARRANGE
+	store, mockDB, queries := newQueryLoggedMockStore(t, WithUnsafeQueryArgs(true))
+	defer store.Close()
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE access=? AND expired_at>? LIMIT 1")).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	// ACTION
+	_, err := store.GetByAccess(context.Background(), "1_1_1")
+
+	// ASSERT
+	assert.NoError(t, err)
+	if assert.Len(t, *queries, 1) {
+		assert.Equal(t, "1_1_1", (*queries)[0].args[0])
+		assert.IsType(t, int64(0), (*queries)[0].args[1])
+	}
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestQueryLogger_ShouldReportGorpInserts(t *testing.T) {
+	// ARRANGE
+	store, mockDB, queries := newQueryLoggedMockStore(t)
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("insert into `oauth2_token`")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	// ACTION
+	err := store.Create(context.Background(), &models.Token{Access: "1_1_1", AccessCreateAt: time.Now(), AccessExpiresIn: time.Hour})
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, []loggedQuery{{query: "gorp insert oauth2_token"}}, *queries)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestQueryLogger_ShouldUseRedactor(t *testing.T) {
+	// ARRANGE
+	store, mockDB, queries := newQueryLoggedMockStore(t, WithRedactor(func(value string) string { return "x" }))
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE `oauth2_token` SET access='' WHERE access=?")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// ACTION
+	err := store.RemoveByAccess(context.Background(), "1_1_1")
+
+	// ASSERT
+	assert.NoError(t, err)
+	if assert.Len(t, *queries, 1) {
+		assert.Equal(t, []interface{}{"x"}, (*queries)[0].args)
+	}
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestCreate_ShouldRedactTokensInErrors(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t)
+	defer store.Close()
+
+	dupErr := &mysqldriver.MySQLError{Number: 1062, Message: "Duplicate entry 'access-token-1234' for key 'uniq_access'"}
+	mockDB.ExpectExec(regexp.QuoteMeta("insert into `oauth2_token`")).
+		WillReturnError(dupErr)
+
+	// ACTION
+	err := store.Create(context.Background(), &models.Token{Access: "access-token-1234", AccessCreateAt: time.Now(), AccessExpiresIn: time.Hour})
+
+	// ASSERT
+	assert.EqualError(t, err, "Error 1062: Duplicate entry '****1234' for key 'uniq_access'")
+	assert.ErrorIs(t, err, dupErr)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestMaskRedactor_ShouldKeepLastFourCharacters(t *testing.T) {
+	assert.Equal(t, "****cdef", MaskRedactor("0123456789abcdef"))
+	assert.Equal(t, "****", MaskRedactor("abcd"))
+	assert.Equal(t, "****", MaskRedactor(""))
+}
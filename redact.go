@@ -0,0 +1,80 @@
+package mysql
+
+import "strings"
+
+// Redactor masks a sensitive value, a token or its data, before it
+// reaches a logger or an error message, see WithRedactor
+type Redactor func(value string) string
+
+// MaskRedactor default redactor, masking all but the last 4 characters
+// of the value. The mask has a fixed length, so it does not reveal the
+// length of the value either, and values of 4 characters or fewer are
+// masked entirely.
+func MaskRedactor(value string) string {
+	const mask = "****"
+	if len(value) <= 4 {
+		return mask
+	}
+	return mask + value[len(value)-4:]
+}
+
+// redactedError error whose message had sensitive values masked, the
+// original error is only reachable through errors.Is and errors.As
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+
+func (e *redactedError) Unwrap() error { return e.err }
+
+// minRedactLen shortest value redactErr masks, shorter ones such as a
+// user id "1" would mask random parts of the message, error numbers
+// included
+const minRedactLen = 5
+
+// redactErr masks the values found in the message of err, such as the
+// token a MySQL duplicate entry error quotes
+func (s *Store) redactErr(err error, values ...string) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	redacted := msg
+	for _, value := range values {
+		if len(value) >= minRedactLen {
+			redacted = strings.ReplaceAll(redacted, value, s.redactor(value))
+		}
+	}
+	if redacted == msg {
+		return err
+	}
+	return &redactedError{msg: redacted, err: err}
+}
+
+// stringArgs returns the string arguments of a statement
+func stringArgs(args []interface{}) []string {
+	var values []string
+	for _, arg := range args {
+		if value, ok := arg.(string); ok {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// itemSecrets returns the token columns of the rows gorp inserts or
+// updates
+func itemSecrets(list []interface{}) []string {
+	var values []string
+	for _, row := range list {
+		switch item := row.(type) {
+		case *StoreItem:
+			values = append(values, item.Code, item.Access, item.Refresh, item.Data)
+		case *UUIDStoreItem:
+			values = append(values, item.Code, item.Access, item.Refresh, item.Data)
+		}
+	}
+	return values
+}
@@ -0,0 +1,74 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+// isTransient reports whether the error is a broken or refused connection
+// worth retrying, as opposed to a query error such as sql.ErrNoRows or a
+// duplicate key
+func isTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, mysqldriver.ErrInvalidConn) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr *net.OpError
+	return errors.As(err, &netErr)
+}
+
+// retry runs fn until it succeeds, fails with a non transient error or
+// the configured attempts are used up, doubling the backoff each time.
+//
+// Retrying Create is safe for the failures retried here: an insert whose
+// connection broke before the server answered is rolled back with the
+// connection, the one exception being a connection lost after the commit
+// itself, which can leave a second row for the same token.
+func (s *Store) retry(ctx context.Context, fn func() error) error {
+	backoff := s.retryBackoff
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if attempt >= s.maxRetries || !isTransient(err) || ctx.Err() != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// startupPing pings the database until it answers or the startup ping
+// attempts are used up, doubling the backoff after each failure
+func (s *Store) startupPing() error {
+	backoff := s.pingBackoff
+	var err error
+	for attempt := 1; attempt <= s.pingAttempts; attempt++ {
+		if err = s.db.Db.Ping(); err == nil {
+			return nil
+		}
+		if attempt < s.pingAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return fmt.Errorf("mysql: database unreachable after %d attempts: %w", s.pingAttempts, err)
+}
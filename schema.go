@@ -0,0 +1,685 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"gopkg.in/gorp.v2"
+)
+
+const (
+	// errDupKeyName mysql error number of ER_DUP_KEYNAME
+	errDupKeyName = 1061
+	// errNoSuchTable mysql error number of ER_NO_SUCH_TABLE
+	errNoSuchTable = 1146
+	// maxVarcharSize largest size gorp maps to VARCHAR instead of TEXT
+	maxVarcharSize = 255
+	// tenantIDSize size of the tenant_id column declared on StoreItem
+	tenantIDSize = 64
+	// clientIDSize size of the client_id column declared on StoreItem
+	clientIDSize = 128
+)
+
+// SQL types of the data column, see WithDataColumnType
+const (
+	DataTypeText    = "TEXT"
+	DataTypeJSON    = "JSON"
+	DataTypeVarchar = "VARCHAR"
+)
+
+// MissingTableAction what the gc does when the token table does not
+// exist, such as after a bad migration, see WithGCMissingTable
+type MissingTableAction int
+
+// Missing table actions of the gc
+const (
+	// MissingTableRetry keeps running the gc, logging the missing table
+	// once until a cycle succeeds again
+	MissingTableRetry MissingTableAction = iota
+	// MissingTableStop logs the missing table and pauses the gc,
+	// SetGCInterval resumes it
+	MissingTableStop
+	// MissingTableRecreate creates the table and its indexes again,
+	// pausing the gc when that fails
+	MissingTableRecreate
+)
+
+// isMissingTable reports whether err is a query on a table that does not
+// exist, MySQL error 1146 or the "no such table" error of SQLite
+func isMissingTable(err error) bool {
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == errNoSuchTable
+	}
+	return err != nil && strings.Contains(err.Error(), "no such table")
+}
+
+// createSchema registers the token table with gorp and creates the
+// table and its indexes when they don't exist yet
+func (s *Store) createSchema() error {
+	if err := s.registerTable(); err != nil {
+		return err
+	}
+	if s.skipCreate {
+		return s.checkTable()
+	}
+	return s.createTables()
+}
+
+// registerTable maps the token table on the DbMap, once when the store is
+// created: the statements of the store read the TableMap concurrently
+func (s *Store) registerTable() error {
+	var row interface{} = StoreItem{}
+	if s.uuidKeys {
+		row = UUIDStoreItem{}
+	}
+	// gorp maps a type to a single table, registering it again under
+	// another name would move the table of another store on a shared map
+	if existing, err := s.db.TableFor(reflect.TypeOf(row), false); err == nil && existing.TableName != s.tableName {
+		return fmt.Errorf("mysql: the DbMap already maps %T to table %s", row, existing.TableName)
+	}
+	table := s.db.AddTableWithName(row, s.tableName)
+	table.ColMap("Code").SetMaxSize(s.sizes.Code)
+	table.ColMap("Access").SetMaxSize(s.sizes.Access)
+	table.ColMap("Refresh").SetMaxSize(s.sizes.Refresh)
+	table.ColMap("Data").SetMaxSize(s.sizes.Data)
+	// single tenant tables don't have the column
+	table.ColMap("TenantID").SetTransient(s.tenantID == "")
+	table.ColMap("ClientID").SetTransient(!s.clientIDColumn)
+	table.ColMap("AccessExpiredAt").SetTransient(!s.splitExpiry)
+	table.ColMap("DeletedAt").SetTransient(!s.softDelete)
+	s.tableMap = table
+	return nil
+}
+
+// createTables creates the registered token table and its indexes when
+// they don't exist yet, only running DDL
+func (s *Store) createTables() error {
+	if err := s.createTable(s.tableMap); err != nil {
+		return fmt.Errorf("mysql: create tables: %w", err)
+	}
+
+	indexes := s.indexes
+	if s.uniqueTokens {
+		indexes = append(indexes[:len(indexes):len(indexes)], uniqueTokenIndexes()...)
+	}
+	if s.clientIDColumn {
+		indexes = append(indexes[:len(indexes):len(indexes)], Index{Name: "idx_client_id", Columns: []string{"client_id"}})
+	}
+	if s.splitExpiry {
+		indexes = append(indexes[:len(indexes):len(indexes)], Index{Name: "idx_access_expired_at", Columns: []string{"access_expired_at"}})
+	}
+	if s.softDelete {
+		indexes = append(indexes[:len(indexes):len(indexes)], Index{Name: "idx_deleted_at", Columns: []string{"deleted_at"}})
+	}
+	legacy, err := s.legacyIndexes()
+	if err != nil {
+		return fmt.Errorf("mysql: list indexes: %w", err)
+	}
+	for _, index := range indexes {
+		if !legacy[index.Name] {
+			index.Name = s.indexName(index.Name)
+		}
+		if s.tenantID != "" {
+			index = tenantIndex(index)
+		}
+		if err := s.createIndex(index); err != nil {
+			return fmt.Errorf("mysql: create index %s: %w", index.Name, err)
+		}
+	}
+	return nil
+}
+
+// createTable creates the token table unless it exists, with the data
+// column type of WithDataColumnType and the table options of
+// WithRowFormat and WithKeyBlockSize
+func (s *Store) createTable(table *gorp.TableMap) error {
+	// a shared map holds the tables of the application too
+	if s.dataType == "" && s.rowFormat == "" && s.keyBlockSize == 0 && len(s.constraints) == 0 && !s.sharedDB {
+		return s.db.CreateTablesIfNotExists()
+	}
+	query := table.SqlForCreate(true)
+	if s.dataType != "" {
+		// gorp only maps go types, swap the generated data column type
+		column := s.db.Dialect.QuoteField("data") + " "
+		query = strings.Replace(query,
+			column+s.db.Dialect.ToSqlType(reflect.TypeOf(""), s.sizes.Data, false),
+			column+s.dataColumnType(), 1)
+	}
+	var options string
+	if s.rowFormat != "" {
+		options += " ROW_FORMAT=" + s.rowFormat
+	}
+	if s.keyBlockSize != 0 {
+		options += fmt.Sprintf(" KEY_BLOCK_SIZE=%d", s.keyBlockSize)
+	}
+	// the constraints go after the columns, inside the parenthesis
+	tableSuffix := ") " + s.db.Dialect.CreateTableSuffix()
+	suffix := s.db.Dialect.QuerySuffix()
+	query = strings.TrimSuffix(strings.TrimSuffix(query, suffix), tableSuffix)
+	for _, constraint := range s.constraints {
+		query += ", " + constraint
+	}
+	query += tableSuffix + options + suffix
+	_, err := s.conn(context.Background(), s.db).Exec(query)
+	return err
+}
+
+// ResetAutoIncrement lowers the autoincrement counter of the token table
+// to the largest id plus one, the server never setting it below, so it is
+// safe on a table holding rows. Deleted rows don't give their ids back:
+// the counter of a table the gc keeps emptying only grows. The signed
+// BIGINT id still lasts some 290,000 years at a million tokens a second,
+// the reset keeps the ids short rather than saving the key space.
+// MySQL only, and not for WithUUIDKeys tables, which have no counter.
+func (s *Store) ResetAutoIncrement(ctx context.Context) error {
+	if !s.isMySQL() {
+		return errors.New("mysql: reset autoincrement: only supported with the MySQL dialect")
+	}
+	if s.uuidKeys {
+		return errors.New("mysql: reset autoincrement: UUID keys have no autoincrement")
+	}
+	ctx, op := s.traceOp(ctx, "ResetAutoIncrement")
+	_, err := s.conn(ctx, s.db).Exec(fmt.Sprintf("ALTER TABLE %s AUTO_INCREMENT = 1", s.table()))
+	err = ctxErr(ctx, err)
+	op.end(err)
+	return err
+}
+
+// resetEmptyAutoIncrement resets the autoincrement of the table once the
+// gc emptied it, see WithGCResetAutoIncrement. The table is counted
+// unscoped, a tenant does not empty a shared table.
+func (s *Store) resetEmptyAutoIncrement(ctx context.Context) (bool, error) {
+	n, err := s.conn(ctx, s.db).SelectInt("SELECT COUNT(*) FROM " + s.table())
+	if err != nil || n > 0 {
+		return false, ctxErr(ctx, err)
+	}
+	return true, s.ResetAutoIncrement(ctx)
+}
+
+// checkAutoIncrement validates WithGCResetAutoIncrement, whose COUNT(*)
+// after every cycle only MyISAM answers without a scan
+func (s *Store) checkAutoIncrement() error {
+	if !s.gcResetAutoInc {
+		return nil
+	}
+	dialect, ok := s.db.Dialect.(gorp.MySQLDialect)
+	if !ok || !strings.EqualFold(dialect.Engine, "MyISAM") {
+		return errors.New("mysql: WithGCResetAutoIncrement requires the MyISAM engine")
+	}
+	if s.uuidKeys {
+		return errors.New("mysql: WithGCResetAutoIncrement can't be combined with WithUUIDKeys")
+	}
+	return nil
+}
+
+// checkTableOptions validates the WithRowFormat and WithKeyBlockSize
+// table options, which only MySQL understands, and the table constraints
+func (s *Store) checkTableOptions() error {
+	for _, constraint := range s.constraints {
+		if strings.TrimSpace(constraint) == "" || strings.Contains(constraint, ";") {
+			return fmt.Errorf("mysql: invalid table constraint %q", constraint)
+		}
+	}
+	if s.rowFormat == "" && s.keyBlockSize == 0 {
+		return nil
+	}
+	if !s.isMySQL() {
+		return errors.New("mysql: row format and key block size require the MySQL dialect")
+	}
+	switch s.rowFormat {
+	case "", "DEFAULT", "DYNAMIC", "FIXED", "COMPRESSED", "REDUNDANT", "COMPACT":
+	default:
+		return fmt.Errorf("mysql: invalid row format %q", s.rowFormat)
+	}
+	switch s.keyBlockSize {
+	case 0, 1, 2, 4, 8, 16:
+	default:
+		return fmt.Errorf("mysql: invalid key block size %d", s.keyBlockSize)
+	}
+	return nil
+}
+
+// dataColumnType returns the SQL type of the configured data column type
+func (s *Store) dataColumnType() string {
+	if s.dataType == DataTypeVarchar {
+		return fmt.Sprintf("varchar(%d)", s.sizes.Data)
+	}
+	return strings.ToLower(s.dataType)
+}
+
+// checkDataType validates the WithDataColumnType type
+func (s *Store) checkDataType() error {
+	switch s.dataType {
+	case "", DataTypeText, DataTypeVarchar:
+		return nil
+	case DataTypeJSON:
+		if s.compress || s.cipher != nil {
+			return errors.New("mysql: a JSON data column can't store compressed or encrypted tokens")
+		}
+		if _, ok := s.codec.(jsoniterCodec); !ok {
+			return errors.New("mysql: a JSON data column requires the default JSON codec")
+		}
+		return nil
+	}
+	return fmt.Errorf("mysql: invalid data column type %q", s.dataType)
+}
+
+// checkTable verifies the token table exists when the store does not
+// create it, only MySQL is checked
+func (s *Store) checkTable() error {
+	if !s.isMySQL() {
+		return nil
+	}
+	n, err := s.conn(context.Background(), s.db).SelectInt("SELECT COUNT(*) FROM information_schema.TABLES "+
+		"WHERE TABLE_SCHEMA=DATABASE() AND TABLE_NAME=?", s.tableName)
+	if err != nil {
+		return fmt.Errorf("mysql: check table: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("mysql: check table: table %s does not exist", s.tableName)
+	}
+	return nil
+}
+
+// indexName derives the index name from the table name, so stores on
+// different tables of one database never share an index name. The
+// default table keeps the plain names, see legacyIndexes for the others.
+func (s *Store) indexName(name string) string {
+	if s.tableName == DefaultTableName {
+		return name
+	}
+	return s.tableName + "_" + name
+}
+
+// legacyIndexes returns the indexes of a table other than the default one
+// created by a version that did not prefix their names with the table
+// name. They keep their plain names, rather than being built again under
+// the prefixed ones.
+func (s *Store) legacyIndexes() (map[string]bool, error) {
+	if s.tableName == DefaultTableName {
+		return nil, nil
+	}
+	var query string
+	switch s.db.Dialect.(type) {
+	case gorp.MySQLDialect:
+		query = "SELECT DISTINCT INDEX_NAME FROM information_schema.STATISTICS WHERE TABLE_SCHEMA=DATABASE() AND TABLE_NAME=?"
+	case gorp.SqliteDialect:
+		query = "SELECT name FROM sqlite_master WHERE type='index' AND tbl_name=?"
+	default:
+		return nil, nil
+	}
+	var names []string
+	if _, err := s.conn(context.Background(), s.db).Select(&names, query, s.tableName); err != nil {
+		return nil, err
+	}
+	legacy := make(map[string]bool, len(names))
+	for _, name := range names {
+		legacy[name] = true
+	}
+	return legacy, nil
+}
+
+// uniqueTokenIndexes returns the indexes created by WithUniqueTokens
+func uniqueTokenIndexes() []Index {
+	return []Index{
+		{Name: "uniq_code", Columns: []string{"code"}, Unique: true},
+		{Name: "uniq_access", Columns: []string{"access"}, Unique: true},
+		{Name: "uniq_refresh", Columns: []string{"refresh"}, Unique: true},
+	}
+}
+
+// isTokenColumn reports whether the column holds a token,
+// cleared to the empty value when the token is removed
+func isTokenColumn(column string) bool {
+	return column == "code" || column == "access" || column == "refresh"
+}
+
+// tenantIndex prefixes the index columns with tenant_id,
+// so tenant scoped queries can use it
+func tenantIndex(index Index) Index {
+	for _, column := range index.Columns {
+		if column == "tenant_id" {
+			return index
+		}
+	}
+	index.Columns = append([]string{"tenant_id"}, index.Columns...)
+	return index
+}
+
+// isMySQL reports whether the store uses the MySQL dialect,
+// other dialects get portable statements
+func (s *Store) isMySQL() bool {
+	_, ok := s.db.Dialect.(gorp.MySQLDialect)
+	return ok
+}
+
+// createIndex creates the index, an index that already exists
+// under the same name is left untouched
+func (s *Store) createIndex(index Index) error {
+	isMySQL := s.isMySQL()
+
+	// unique indexes must not see the empty value of removed tokens,
+	// unless they are cleared to NULL MySQL indexes NULLIF(column, '')
+	// instead and other dialects get a partial index
+	var conds []string
+	columns := make([]string, len(index.Columns))
+	for i, column := range index.Columns {
+		columns[i] = s.db.Dialect.QuoteField(column)
+		switch {
+		case index.Unique && isTokenColumn(column) && s.nullTokens:
+			// NULL never collides
+		case index.Unique && isTokenColumn(column) && isMySQL:
+			if s.columnSize(column) > maxVarcharSize {
+				return fmt.Errorf("unique index on TEXT column %s", column)
+			}
+			columns[i] = fmt.Sprintf("(nullif(%s, ''))", columns[i])
+		case index.Unique && isTokenColumn(column):
+			conds = append(conds, columns[i]+" <> ''")
+		case isMySQL && s.columnSize(column) > maxVarcharSize:
+			// TEXT columns can only be indexed on a prefix
+			columns[i] += fmt.Sprintf("(%d)", maxVarcharSize)
+		}
+	}
+
+	kind := "index"
+	if index.Unique {
+		kind = "unique index"
+	}
+	var query string
+	if isMySQL {
+		query = fmt.Sprintf("create %s %s on %s (%s) using btree", kind, index.Name, s.table(), strings.Join(columns, ", "))
+	} else {
+		query = fmt.Sprintf("create %s if not exists %s on %s (%s)", kind, index.Name, s.table(), strings.Join(columns, ", "))
+		if len(conds) > 0 {
+			query += " where " + strings.Join(conds, " and ")
+		}
+	}
+
+	_, err := s.conn(context.Background(), s.db).Exec(query + s.db.Dialect.QuerySuffix())
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == errDupKeyName {
+		return nil
+	}
+	return err
+}
+
+// columnSize returns the configured size of a string column
+func (s *Store) columnSize(column string) int {
+	switch column {
+	case "code":
+		return s.sizes.Code
+	case "access":
+		return s.sizes.Access
+	case "refresh":
+		return s.sizes.Refresh
+	case "data":
+		return s.sizes.Data
+	}
+	return 0
+}
+
+// Migrate brings an existing token table in line with the configured
+// column sizes and storage engine, adding the tenant_id, client_id,
+// access_expired_at and deleted_at columns the store options require,
+// returning the statements it executed.
+// Columns are only ever widened, never shrunk, so running it repeatedly
+// is a no-op once the table matches. A column widened past 255 becomes
+// TEXT, its indexes are rebuilt on a 255 characters prefix in the same
+// statement, a unique index on it is refused.
+func (s *Store) Migrate(ctx context.Context) ([]string, error) {
+	if !s.isMySQL() {
+		return nil, errors.New("mysql: migrate: only supported with the MySQL dialect")
+	}
+	db := s.conn(ctx, s.db)
+
+	var columns []struct {
+		Name   string         `db:"COLUMN_NAME"`
+		Type   string         `db:"DATA_TYPE"`
+		Length sql.NullInt64  `db:"CHARACTER_MAXIMUM_LENGTH"`
+		Engine sql.NullString `db:"ENGINE"`
+	}
+	_, err := db.Select(&columns, "SELECT c.COLUMN_NAME, c.DATA_TYPE, c.CHARACTER_MAXIMUM_LENGTH, t.ENGINE "+
+		"FROM information_schema.COLUMNS c JOIN information_schema.TABLES t "+
+		"ON t.TABLE_SCHEMA=c.TABLE_SCHEMA AND t.TABLE_NAME=c.TABLE_NAME "+
+		"WHERE c.TABLE_SCHEMA=DATABASE() AND c.TABLE_NAME=?", s.tableName)
+	if err != nil {
+		return nil, fmt.Errorf("mysql: migrate: %w", ctxErr(ctx, err))
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("mysql: migrate: table %s does not exist", s.tableName)
+	}
+
+	var stmts []string
+	var toText []string
+	hasTenant, hasClientID, hasAccessExpiry, hasDeletedAt := false, false, false, false
+	for _, column := range columns {
+		switch column.Name {
+		case "tenant_id":
+			hasTenant = true
+		case "client_id":
+			hasClientID = true
+		case "access_expired_at":
+			hasAccessExpiry = true
+		case "deleted_at":
+			hasDeletedAt = true
+		}
+		size := s.columnSize(column.Name)
+		if size == 0 {
+			continue
+		}
+		current := strings.ToLower(column.Type)
+		// Only widen: VARCHAR to a longer VARCHAR or to TEXT
+		if current == "varchar" && size > maxVarcharSize {
+			toText = append(toText, column.Name)
+		} else if current == "varchar" && int64(size) > column.Length.Int64 {
+			stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s MODIFY %s %s", s.table(),
+				s.db.Dialect.QuoteField(column.Name), s.db.Dialect.ToSqlType(reflect.TypeOf(""), size, false)))
+		}
+	}
+	if len(toText) > 0 {
+		stmt, err := s.textColumnsStmt(db, toText)
+		if err != nil {
+			return nil, fmt.Errorf("mysql: migrate: %w", ctxErr(ctx, err))
+		}
+		stmts = append(stmts, stmt)
+	}
+
+	if s.tenantID != "" && !hasTenant {
+		stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s NOT NULL DEFAULT ''", s.table(),
+			s.db.Dialect.QuoteField("tenant_id"), s.db.Dialect.ToSqlType(reflect.TypeOf(""), tenantIDSize, false)))
+	}
+	if s.clientIDColumn && !hasClientID {
+		stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", s.table(),
+			s.db.Dialect.QuoteField("client_id"), s.db.Dialect.ToSqlType(reflect.TypeOf(""), clientIDSize, false)),
+			fmt.Sprintf("CREATE INDEX %s ON %s (%s)", s.indexName("idx_client_id"), s.table(), s.db.Dialect.QuoteField("client_id")))
+	}
+	if s.splitExpiry && !hasAccessExpiry {
+		// existing access tokens keep living as long as their row
+		stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s bigint NOT NULL DEFAULT 0", s.table(),
+			s.db.Dialect.QuoteField("access_expired_at")),
+			fmt.Sprintf("UPDATE %s SET %s=%s", s.table(), s.db.Dialect.QuoteField("access_expired_at"), s.db.Dialect.QuoteField("expired_at")),
+			fmt.Sprintf("CREATE INDEX %s ON %s (%s)", s.indexName("idx_access_expired_at"), s.table(), s.db.Dialect.QuoteField("access_expired_at")))
+	}
+	if s.softDelete && !hasDeletedAt {
+		// existing rows are live
+		stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s bigint NOT NULL DEFAULT 0", s.table(),
+			s.db.Dialect.QuoteField("deleted_at")),
+			fmt.Sprintf("CREATE INDEX %s ON %s (%s)", s.indexName("idx_deleted_at"), s.table(), s.db.Dialect.QuoteField("deleted_at")))
+	}
+
+	dialect := s.db.Dialect.(gorp.MySQLDialect)
+	if !identifierRegexp.MatchString(dialect.Engine) {
+		return nil, fmt.Errorf("mysql: migrate: invalid engine %q", dialect.Engine)
+	}
+	if engine := columns[0].Engine.String; !strings.EqualFold(engine, dialect.Engine) {
+		stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s ENGINE=%s", s.table(), dialect.Engine))
+	}
+
+	for i, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return stmts[:i], fmt.Errorf("mysql: migrate: %s: %w", stmt, ctxErr(ctx, err))
+		}
+	}
+	return stmts, nil
+}
+
+// textColumnsStmt returns the ALTER TABLE turning the VARCHAR columns into
+// TEXT. MySQL only indexes a prefix of a TEXT column, so the same statement
+// rebuilds the indexes of the columns on their first 255 characters, as
+// createIndex does for new tables.
+func (s *Store) textColumnsStmt(db gorp.SqlExecutor, columns []string) (string, error) {
+	var parts []struct {
+		Index     string        `db:"INDEX_NAME"`
+		Column    string        `db:"COLUMN_NAME"`
+		SubPart   sql.NullInt64 `db:"SUB_PART"`
+		NonUnique bool          `db:"NON_UNIQUE"`
+	}
+	_, err := db.Select(&parts, "SELECT INDEX_NAME, COALESCE(COLUMN_NAME, '') AS COLUMN_NAME, SUB_PART, NON_UNIQUE "+
+		"FROM information_schema.STATISTICS WHERE TABLE_SCHEMA=DATABASE() AND TABLE_NAME=? "+
+		"ORDER BY INDEX_NAME, SEQ_IN_INDEX", s.tableName)
+	if err != nil {
+		return "", err
+	}
+
+	text := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		text[column] = true
+	}
+	var names []string
+	indexes := make(map[string][]string)
+	affected := make(map[string]bool)
+	for _, part := range parts {
+		if text[part.Column] {
+			if !part.NonUnique {
+				return "", fmt.Errorf("unique index %s can't be kept on TEXT column %s, drop it first", part.Index, part.Column)
+			}
+			affected[part.Index] = true
+		}
+		if _, ok := indexes[part.Index]; !ok {
+			names = append(names, part.Index)
+		}
+		column := s.db.Dialect.QuoteField(part.Column)
+		switch {
+		case text[part.Column]:
+			column += fmt.Sprintf("(%d)", maxVarcharSize)
+		case part.SubPart.Valid:
+			column += fmt.Sprintf("(%d)", part.SubPart.Int64)
+		}
+		indexes[part.Index] = append(indexes[part.Index], column)
+	}
+
+	var clauses []string
+	for _, name := range names {
+		if affected[name] {
+			clauses = append(clauses, "DROP INDEX "+s.db.Dialect.QuoteField(name))
+		}
+	}
+	for _, column := range columns {
+		clauses = append(clauses, fmt.Sprintf("MODIFY %s %s", s.db.Dialect.QuoteField(column),
+			s.db.Dialect.ToSqlType(reflect.TypeOf(""), s.columnSize(column), false)))
+	}
+	for _, name := range names {
+		if affected[name] {
+			clauses = append(clauses, fmt.Sprintf("ADD INDEX %s (%s)", s.db.Dialect.QuoteField(name), strings.Join(indexes[name], ", ")))
+		}
+	}
+	return fmt.Sprintf("ALTER TABLE %s %s", s.table(), strings.Join(clauses, ", ")), nil
+}
+
+// ConvertEngine changes the storage engine of the token table, for example
+// from MyISAM to InnoDB, in a single ALTER TABLE that copies every row.
+// The estimated row count and the elapsed time are reported to the logger
+// when it implements InfoLogger. It is a no-op when the table already uses
+// the engine. The query timeout does not apply, ctx bounds the conversion.
+func (s *Store) ConvertEngine(ctx context.Context, engine string) error {
+	ctx, op := s.traceOp(ctx, "ConvertEngine")
+	rows, err := s.convertEngine(ctx, engine)
+	op.setRowsAffected(rows)
+	op.end(err)
+	return err
+}
+
+func (s *Store) convertEngine(ctx context.Context, engine string) (int64, error) {
+	if !s.isMySQL() {
+		return 0, errors.New("mysql: convert engine: only supported with the MySQL dialect")
+	}
+	if !identifierRegexp.MatchString(engine) {
+		return 0, fmt.Errorf("mysql: convert engine: invalid engine %q", engine)
+	}
+	db := s.conn(ctx, s.db)
+
+	var tables []struct {
+		Engine sql.NullString `db:"ENGINE"`
+		Rows   sql.NullInt64  `db:"TABLE_ROWS"`
+	}
+	_, err := db.Select(&tables, "SELECT ENGINE, TABLE_ROWS FROM information_schema.TABLES "+
+		"WHERE TABLE_SCHEMA=DATABASE() AND TABLE_NAME=?", s.tableName)
+	if err != nil {
+		return 0, fmt.Errorf("mysql: convert engine: %w", ctxErr(ctx, err))
+	}
+	if len(tables) == 0 {
+		return 0, fmt.Errorf("mysql: convert engine: table %s does not exist", s.tableName)
+	}
+	current, rows := tables[0].Engine.String, tables[0].Rows.Int64
+	if strings.EqualFold(current, engine) {
+		return 0, nil
+	}
+
+	s.infof("converting table %s from %s to %s, about %d rows", s.tableName, current, engine, rows)
+	start := time.Now()
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ENGINE=%s", s.table(), engine)); err != nil {
+		return 0, fmt.Errorf("mysql: convert engine: %w", ctxErr(ctx, err))
+	}
+	s.infof("converted table %s to %s in %s", s.tableName, engine, time.Since(start).Round(time.Millisecond))
+	return rows, nil
+}
+
+// ColumnInfo live definition of a token table column, see DescribeSchema
+type ColumnInfo struct {
+	Name string
+	// Type full column type, such as varchar(255) or bigint(20)
+	Type string
+	// Size maximum length of character columns, 0 for other types
+	Size     int64
+	Nullable bool
+}
+
+// DescribeSchema returns the columns of the token table as the database
+// reports them, in table order, to check a deployment for drift
+func (s *Store) DescribeSchema(ctx context.Context) ([]ColumnInfo, error) {
+	if !s.isMySQL() {
+		return nil, errors.New("mysql: describe schema: only supported with the MySQL dialect")
+	}
+	var rows []struct {
+		Name     string        `db:"COLUMN_NAME"`
+		Type     string        `db:"COLUMN_TYPE"`
+		Size     sql.NullInt64 `db:"CHARACTER_MAXIMUM_LENGTH"`
+		Nullable string        `db:"IS_NULLABLE"`
+	}
+	_, err := s.conn(ctx, s.reader(ctx)).Select(&rows, "SELECT COLUMN_NAME, COLUMN_TYPE, CHARACTER_MAXIMUM_LENGTH, IS_NULLABLE "+
+		"FROM information_schema.COLUMNS WHERE TABLE_SCHEMA=DATABASE() AND TABLE_NAME=? ORDER BY ORDINAL_POSITION", s.tableName)
+	if err != nil {
+		return nil, fmt.Errorf("mysql: describe schema: %w", ctxErr(ctx, err))
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("mysql: describe schema: table %s does not exist", s.tableName)
+	}
+
+	columns := make([]ColumnInfo, len(rows))
+	for i, row := range rows {
+		columns[i] = ColumnInfo{
+			Name:     row.Name,
+			Type:     row.Type,
+			Size:     row.Size.Int64,
+			Nullable: row.Nullable == "YES",
+		}
+	}
+	return columns, nil
+}
@@ -0,0 +1,201 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sync"
+
+	"github.com/go-oauth2/oauth2/v4"
+)
+
+// ShardedStore token store spreading the token rows over several stores,
+// usually on different databases, by a hash of the token. A row lives on
+// the shard of its authorization code, or of its access token for rows
+// of access and refresh tokens, so the lookups and removals by code and
+// access token only query that shard. Refresh tokens hash to another
+// shard than their row, the lookups and removals by refresh token query
+// every shard concurrently.
+type ShardedStore struct {
+	shards []*Store
+	hash   func(key string) uint32
+}
+
+var _ oauth2.TokenStore = (*ShardedStore)(nil)
+
+// NewShardedStore create a sharded store over the stores, routing the
+// tokens by hash, FNV-1a when nil. The shard of a token depends on the
+// number and order of the stores, which must not change once tokens are
+// stored. Each store keeps running its own gc.
+func NewShardedStore(shards []*Store, hash func(key string) uint32) (*ShardedStore, error) {
+	if len(shards) == 0 {
+		return nil, errors.New("mysql: sharded store: no shards")
+	}
+	if hash == nil {
+		hash = fnvHash
+	}
+	return &ShardedStore{shards: shards, hash: hash}, nil
+}
+
+// fnvHash default hash of the sharded store
+func fnvHash(key string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum32()
+}
+
+// Shards returns the stores of the shards, in routing order
+func (s *ShardedStore) Shards() []*Store {
+	return s.shards
+}
+
+// shard returns the store holding the rows of the key
+func (s *ShardedStore) shard(key string) *Store {
+	return s.shards[s.hash(key)%uint32(len(s.shards))]
+}
+
+// rowShard returns the store the row of the token information goes to
+func (s *ShardedStore) rowShard(info oauth2.TokenInfo) *Store {
+	if code := info.GetCode(); code != "" {
+		return s.shard(code)
+	}
+	if access := info.GetAccess(); access != "" {
+		return s.shard(access)
+	}
+	return s.shard(info.GetRefresh())
+}
+
+// each runs fn on every shard concurrently, returning the first error
+func (s *ShardedStore) each(fn func(store *Store) error) error {
+	errs := make([]error, len(s.shards))
+	var wg sync.WaitGroup
+	for i, store := range s.shards {
+		wg.Add(1)
+		go func(i int, store *Store) {
+			defer wg.Done()
+			errs[i] = fn(store)
+		}(i, store)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes every shard
+func (s *ShardedStore) Close() {
+	for _, store := range s.shards {
+		store.Close()
+	}
+}
+
+// Create create and store the new token information on its shard
+func (s *ShardedStore) Create(ctx context.Context, info oauth2.TokenInfo) error {
+	return s.rowShard(info).Create(ctx, info)
+}
+
+// Update replace the stored token information on its shard
+func (s *ShardedStore) Update(ctx context.Context, info oauth2.TokenInfo) error {
+	return s.rowShard(info).Update(ctx, info)
+}
+
+// RemoveByCode delete the authorization code
+func (s *ShardedStore) RemoveByCode(ctx context.Context, code string) error {
+	return s.shard(code).RemoveByCode(ctx, code)
+}
+
+// RemoveByAccess use the access token to delete the token information
+func (s *ShardedStore) RemoveByAccess(ctx context.Context, access string) error {
+	return s.shard(access).RemoveByAccess(ctx, access)
+}
+
+// RemoveByRefresh use the refresh token to delete the token information,
+// on every shard. With WithNotFoundError ErrNotFound is only returned
+// when no shard held the token.
+func (s *ShardedStore) RemoveByRefresh(ctx context.Context, refresh string) error {
+	var mu sync.Mutex
+	var removed int64
+	err := s.each(func(store *Store) error {
+		n, err := store.RemoveByRefreshCount(ctx, refresh)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		mu.Lock()
+		removed += n
+		mu.Unlock()
+		return err
+	})
+	if err == nil && removed == 0 && s.shards[0].notFoundError {
+		return ErrNotFound
+	}
+	return err
+}
+
+// RevokeByClientID revoke every token issued to the client on every
+// shard, returning the total number of rows deleted
+func (s *ShardedStore) RevokeByClientID(ctx context.Context, clientID string) (int64, error) {
+	var mu sync.Mutex
+	var total int64
+	err := s.each(func(store *Store) error {
+		n, err := store.RevokeByClientID(ctx, clientID)
+		mu.Lock()
+		total += n
+		mu.Unlock()
+		return err
+	})
+	return total, err
+}
+
+// GetByCode use the authorization code for token information data
+func (s *ShardedStore) GetByCode(ctx context.Context, code string) (oauth2.TokenInfo, error) {
+	return s.shard(code).GetByCode(ctx, code)
+}
+
+// GetByAccess use the access token for token information data
+func (s *ShardedStore) GetByAccess(ctx context.Context, access string) (oauth2.TokenInfo, error) {
+	return s.shard(access).GetByAccess(ctx, access)
+}
+
+// GetByRefresh use the refresh token for token information data,
+// querying every shard
+func (s *ShardedStore) GetByRefresh(ctx context.Context, refresh string) (oauth2.TokenInfo, error) {
+	var mu sync.Mutex
+	var found oauth2.TokenInfo
+	err := s.each(func(store *Store) error {
+		info, err := store.GetByRefresh(ctx, refresh)
+		if errors.Is(err, ErrTokenNotFound) {
+			return nil
+		}
+		if info != nil {
+			mu.Lock()
+			found = info
+			mu.Unlock()
+		}
+		return err
+	})
+	if found != nil {
+		return found, nil
+	}
+	if err == nil {
+		err = s.shards[0].notFound()
+	}
+	return nil, err
+}
+
+// PurgeExpired runs PurgeExpired on every shard, returning the total
+// number of rows deleted
+func (s *ShardedStore) PurgeExpired(ctx context.Context) (int64, error) {
+	var mu sync.Mutex
+	var total int64
+	err := s.each(func(store *Store) error {
+		n, err := store.PurgeExpired(ctx)
+		mu.Lock()
+		total += n
+		mu.Unlock()
+		return err
+	})
+	return total, err
+}
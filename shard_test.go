@@ -0,0 +1,91 @@
+package mysql
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-oauth2/oauth2/v4/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewShardedStore_ShouldRequireShards(t *testing.T) {
+	// ACTION
+	_, err := NewShardedStore(nil, nil)
+
+	// ASSERT
+	assert.EqualError(t, err, "mysql: sharded store: no shards")
+}
+
+func TestShardedStore_ShouldRouteByTokenHash(t *testing.T) {
+	// ARRANGE
+	first, firstDB := newMockStore(t)
+	second, secondDB := newMockStore(t)
+	hash := func(key string) uint32 {
+		if strings.HasPrefix(key, "b") {
+			return 1
+		}
+		return 0
+	}
+	store, err := NewShardedStore([]*Store{first, second}, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	secondDB.ExpectExec(regexp.QuoteMeta("insert into `oauth2_token`")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	firstDB.ExpectExec(regexp.QuoteMeta("UPDATE `oauth2_token` SET code='' WHERE code=?")).
+		WithArgs("a_code").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// ACTION
+	createErr := store.Create(context.Background(), &models.Token{Access: "b_access", AccessCreateAt: time.Now(), AccessExpiresIn: time.Hour})
+	removeErr := store.RemoveByCode(context.Background(), "a_code")
+
+	// ASSERT
+	assert.NoError(t, createErr)
+	assert.NoError(t, removeErr)
+	assert.NoError(t, firstDB.ExpectationsWereMet())
+	assert.NoError(t, secondDB.ExpectationsWereMet())
+}
+
+func TestShardedStore_ShouldRevokeByClientIDOnEveryShard(t *testing.T) {
+	// ARRANGE
+	var shards []*Store
+	var mocks []sqlmock.Sqlmock
+	for i := 0; i < 2; i++ {
+		db, mockDB, _ := sqlmock.New()
+		mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists")).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		expectDefaultIndexes(mockDB)
+		mockDB.ExpectExec(regexp.QuoteMeta("create index idx_client_id on")).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		shards = append(shards, NewStoreWithOpts(db, WithClientIDColumn(true), WithGCTimeInterval(-1)))
+		mocks = append(mocks, mockDB)
+	}
+	store, err := NewShardedStore(shards, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	for i, mockDB := range mocks {
+		mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM `oauth2_token` WHERE client_id=?")).
+			WithArgs("1").
+			WillReturnResult(sqlmock.NewResult(0, int64(i+1)))
+	}
+
+	// ACTION
+	n, err := store.RevokeByClientID(context.Background(), "1")
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	for _, mockDB := range mocks {
+		assert.NoError(t, mockDB.ExpectationsWereMet())
+	}
+}
@@ -0,0 +1,68 @@
+package mysql
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// notDeleted adds to the condition the exclusion of the rows revoked in
+// soft delete mode, see WithSoftDelete
+func (s *Store) notDeleted(cond string) string {
+	if !s.softDelete {
+		return cond
+	}
+	if strings.Contains(cond, " OR ") {
+		cond = "(" + cond + ")"
+	}
+	return cond + " AND deleted_at=0"
+}
+
+// removeArgs returns the arguments of a removal matching the value,
+// preceded by the deletion time in soft delete mode
+func (s *Store) removeArgs(value string) []interface{} {
+	if !s.softDelete {
+		return s.scopeArgs(value)
+	}
+	return s.scopeArgs(s.now().Unix(), value)
+}
+
+// purgeArgs returns the arguments of the purge condition,
+// followed by the end of the retention in soft delete mode
+func (s *Store) purgeArgs(now int64) []interface{} {
+	if !s.softDelete {
+		return s.scopeArgs(now)
+	}
+	return s.scopeArgs(now, s.retentionEnd(now))
+}
+
+// retentionEnd returns the deletion time before which the revoked rows
+// are past their retention
+func (s *Store) retentionEnd(now int64) int64 {
+	return now - int64(s.softRetention.Seconds())
+}
+
+// retained adds to the condition of a deletion the exclusion of the rows
+// revoked within the retention in soft delete mode, the end of the
+// retention following the arguments of the condition
+func (s *Store) retained(cond string, args ...interface{}) (string, []interface{}) {
+	if !s.softDelete {
+		return cond, args
+	}
+	return fmt.Sprintf("(%s) AND (deleted_at=0 OR deleted_at<=?)", cond), append(args, s.retentionEnd(s.now().Unix()))
+}
+
+// softPurgeCond matches the rows the gc deletes in soft delete mode: the
+// expired or emptied rows still live, and the revoked rows whose
+// retention ended
+func (s *Store) softPurgeCond(cond string) string {
+	return fmt.Sprintf("((%s) AND deleted_at=0) OR (deleted_at<>0 AND deleted_at<=?)", cond)
+}
+
+// checkSoftDelete validates the WithSoftDelete options
+func (s *Store) checkSoftDelete() error {
+	if s.softDelete && s.hardDelete {
+		return errors.New("mysql: WithSoftDelete can't be combined with WithHardDelete")
+	}
+	return nil
+}
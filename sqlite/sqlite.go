@@ -0,0 +1,35 @@
+// Package sqlite runs the token store on an in-memory SQLite database,
+// so code using the store can be tested without a MySQL server.
+// It needs cgo for the github.com/mattn/go-sqlite3 driver.
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/codebeautiful/mysql/v4"
+	_ "github.com/mattn/go-sqlite3"
+	"gopkg.in/gorp.v2"
+)
+
+// NewStore create token store instance backed by a fresh in-memory
+// SQLite database with the same schema, the background gc is disabled
+// unless enabled by the options. Closing the store drops the database.
+func NewStore(opts ...mysql.Option) (*mysql.Store, error) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: open: %w", err)
+	}
+	// every connection gets its own in-memory database, keep a single one
+	db.SetMaxOpenConns(1)
+
+	store, err := mysql.NewStoreWithOptsE(db, append([]mysql.Option{
+		mysql.WithDialect(gorp.SqliteDialect{}),
+		mysql.WithGCTimeInterval(-1),
+	}, opts...)...)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return store, nil
+}
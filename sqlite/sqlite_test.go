@@ -0,0 +1,800 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/codebeautiful/mysql/v4"
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewStore_ShouldStoreTokens(t *testing.T) {
+	// ARRANGE
+	store, err := NewStore(mysql.WithHardDelete(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	info := &models.Token{
+		ClientID:         "1",
+		UserID:           "1_1",
+		Access:           "1_1_1",
+		AccessCreateAt:   time.Now(),
+		AccessExpiresIn:  time.Hour,
+		Refresh:          "2_2_2",
+		RefreshCreateAt:  time.Now(),
+		RefreshExpiresIn: time.Hour * 24,
+	}
+
+	// ACTION & ASSERT
+	assert.NoError(t, store.Create(ctx, info))
+
+	// Create rejects expired tokens, write one directly
+	tx, err := store.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tx.Exec("INSERT INTO oauth2_token (expired_at, code, access, refresh, data, user_id) VALUES (?, '11_11_11', '', '', '{}', '')",
+		time.Now().Add(-time.Hour).Unix())
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit())
+
+	got, err := store.GetByRefresh(ctx, "2_2_2")
+	if assert.NoError(t, err) && assert.NotNil(t, got) {
+		assert.Equal(t, "1_1_1", got.GetAccess())
+	}
+
+	info.Scope = "all"
+	assert.NoError(t, store.Update(ctx, info))
+	got, err = store.GetByAccess(ctx, "1_1_1")
+	if assert.NoError(t, err) && assert.NotNil(t, got) {
+		assert.Equal(t, "all", got.GetScope())
+	}
+
+	n, err := store.PurgeExpired(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	assert.NoError(t, store.RemoveByAccess(ctx, "1_1_1"))
+	got, err = store.GetByAccess(ctx, "1_1_1")
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+
+	total, err := store.CountAll(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), total)
+}
+
+func TestNewStore_ShouldRejectDuplicateTokensWithUniqueTokens(t *testing.T) {
+	// ARRANGE
+	store, err := NewStore(mysql.WithUniqueTokens(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	newToken := func(access string) *models.Token {
+		return &models.Token{Access: access, AccessCreateAt: time.Now(), AccessExpiresIn: time.Hour}
+	}
+
+	// ACTION & ASSERT
+	assert.NoError(t, store.Create(ctx, newToken("1_1_1")))
+	assert.Error(t, store.Create(ctx, newToken("1_1_1")))
+
+	// removed tokens share the empty value
+	assert.NoError(t, store.Create(ctx, newToken("2_2_2")))
+	assert.NoError(t, store.RemoveByAccess(ctx, "1_1_1"))
+	assert.NoError(t, store.RemoveByAccess(ctx, "2_2_2"))
+	assert.NoError(t, store.Create(ctx, newToken("1_1_1")))
+}
+
+func TestNewStore_ShouldClearTokensToNullWithNullTokens(t *testing.T) {
+	// ARRANGE
+	store, err := NewStore(mysql.WithNullTokens(true), mysql.WithUniqueTokens(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	// ACTION & ASSERT
+	for _, access := range []string{"1_1_1", "2_2_2"} {
+		assert.NoError(t, store.Create(ctx, &models.Token{Access: access, AccessCreateAt: time.Now(), AccessExpiresIn: time.Hour}))
+	}
+	got, err := store.GetByAccess(ctx, "2_2_2")
+	if assert.NoError(t, err) && assert.NotNil(t, got) {
+		assert.Equal(t, "", got.GetRefresh())
+	}
+
+	assert.NoError(t, store.RemoveByAccess(ctx, "1_1_1"))
+	n, err := store.PurgeExpired(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	items, total, err := store.List(ctx, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	if assert.Len(t, items, 1) {
+		assert.Equal(t, "", items[0].Code)
+		assert.Equal(t, "2_2_2", items[0].Access)
+	}
+}
+
+func TestNewStore_ShouldCreateBatch(t *testing.T) {
+	// ARRANGE
+	store, err := NewStore(mysql.WithInsertBatchSize(3), mysql.WithNullTokens(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	// ACTION
+	err = store.CreateBatch(ctx, newTokens(0, 10))
+
+	// ASSERT
+	assert.NoError(t, err)
+	total, err := store.CountAll(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), total)
+	got, err := store.GetByAccess(ctx, "access_7")
+	if assert.NoError(t, err) && assert.NotNil(t, got) {
+		assert.Equal(t, "user_7", got.GetUserID())
+	}
+}
+
+func newTokens(from, n int) []oauth2.TokenInfo {
+	infos := make([]oauth2.TokenInfo, n)
+	for i := range infos {
+		infos[i] = &models.Token{
+			ClientID:        "1",
+			UserID:          fmt.Sprintf("user_%d", from+i),
+			Access:          fmt.Sprintf("access_%d", from+i),
+			AccessCreateAt:  time.Now(),
+			AccessExpiresIn: time.Hour,
+		}
+	}
+	return infos
+}
+
+func BenchmarkCreate(b *testing.B) {
+	store, err := NewStore()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	infos := newTokens(0, b.N)
+
+	b.ResetTimer()
+	for _, info := range infos {
+		if err := store.Create(ctx, info); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCreateBatch(b *testing.B) {
+	store, err := NewStore()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	infos := newTokens(0, b.N)
+
+	b.ResetTimer()
+	if err := store.CreateBatch(ctx, infos); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkGetByAccess(b *testing.B) {
+	for name, cached := range map[string]bool{"uncached": false, "cached": true} {
+		b.Run(name, func(b *testing.B) {
+			store, err := NewStore(mysql.WithStatementCache(cached))
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer store.Close()
+			ctx := context.Background()
+			info := newTokens(0, 1)[0]
+			if err := store.Create(ctx, info); err != nil {
+				b.Fatal(err)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := store.GetByAccess(ctx, info.GetAccess()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestNewStore_ShouldHideTokensOnceExpired(t *testing.T) {
+	for _, readExpired := range []bool{false, true} {
+		// ARRANGE
+		now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		store, err := NewStore(
+			mysql.WithNowFunc(func() time.Time { return now }),
+			mysql.WithReadExpired(readExpired),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		ctx := context.Background()
+		assert.NoError(t, store.Create(ctx, &models.Token{Access: "1_1_1", AccessCreateAt: now, AccessExpiresIn: time.Hour}))
+
+		// ACTION
+		now = now.Add(time.Hour - time.Second)
+		beforeExpiry, beforeErr := store.GetByAccess(ctx, "1_1_1")
+		now = now.Add(time.Second)
+		atExpiry, atErr := store.GetByAccess(ctx, "1_1_1")
+		item, itemErr := store.GetItemByAccess(ctx, "1_1_1")
+
+		// ASSERT
+		assert.NoError(t, beforeErr)
+		assert.NotNil(t, beforeExpiry)
+		assert.NoError(t, atErr)
+		if readExpired {
+			assert.NotNil(t, atExpiry)
+		} else {
+			assert.Nil(t, atExpiry)
+		}
+		assert.NoError(t, itemErr)
+		assert.NotNil(t, item)
+		store.Close()
+	}
+}
+
+func TestNewStore_ShouldCreateIfAbsent(t *testing.T) {
+	// ARRANGE
+	store, err := NewStore(mysql.WithUniqueTokens(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	info := &models.Token{Access: "1_1_1", AccessCreateAt: time.Now(), AccessExpiresIn: time.Hour}
+
+	// ACTION
+	first, firstErr := store.CreateIfAbsent(ctx, info)
+	second, secondErr := store.CreateIfAbsent(ctx, info)
+
+	// ASSERT
+	assert.NoError(t, firstErr)
+	assert.True(t, first)
+	assert.NoError(t, secondErr)
+	assert.False(t, second)
+	total, err := store.CountAll(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+}
+
+func TestNewStore_ShouldStoreTokensWithUUIDKeys(t *testing.T) {
+	// ARRANGE
+	store, err := NewStore(mysql.WithUUIDKeys(true), mysql.WithUniqueTokens(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	newToken := func(access string) *models.Token {
+		return &models.Token{Access: access, AccessCreateAt: time.Now(), AccessExpiresIn: time.Hour}
+	}
+
+	// ACTION & ASSERT
+	assert.NoError(t, store.Create(ctx, newToken("1_1_1")))
+	assert.NoError(t, store.CreateBatch(ctx, []oauth2.TokenInfo{newToken("2_2_2"), newToken("3_3_3")}))
+	inserted, err := store.CreateIfAbsent(ctx, newToken("4_4_4"))
+	assert.NoError(t, err)
+	assert.True(t, inserted)
+
+	before, err := store.GetItemByAccess(ctx, "1_1_1")
+	if assert.NoError(t, err) && assert.NotNil(t, before) {
+		assert.Len(t, before.UUID, 36)
+	}
+	updated := newToken("1_1_1")
+	updated.Scope = "all"
+	assert.NoError(t, store.Update(ctx, updated))
+	after, err := store.GetItemByAccess(ctx, "1_1_1")
+	if assert.NoError(t, err) && assert.NotNil(t, after) && before != nil {
+		assert.Equal(t, before.UUID, after.UUID)
+	}
+
+	items, total, err := store.List(ctx, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), total)
+	seen := make(map[string]bool)
+	assert.NoError(t, store.ForEach(ctx, func(item *mysql.StoreItem) error {
+		seen[item.UUID] = true
+		return nil
+	}))
+	assert.Len(t, seen, 4)
+	for _, item := range items {
+		assert.True(t, seen[item.UUID])
+	}
+
+	assert.NoError(t, store.RemoveByAccess(ctx, "2_2_2"))
+	n, err := store.PurgeExpired(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+}
+
+func TestNewStore_ShouldRemoveByUserAndClient(t *testing.T) {
+	// ARRANGE
+	store, err := NewStore(mysql.WithClientIDColumn(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	for i, owner := range [][2]string{{"c1", "u1"}, {"c1", "u2"}, {"c2", "u1"}} {
+		assert.NoError(t, store.Create(ctx, &models.Token{
+			ClientID:        owner[0],
+			UserID:          owner[1],
+			Access:          fmt.Sprintf("access_%d", i),
+			AccessCreateAt:  time.Now(),
+			AccessExpiresIn: time.Hour,
+		}))
+	}
+
+	// ACTION
+	byUser, userErr := store.RemoveByUserID(ctx, "u1")
+	byClient, clientErr := store.RemoveByClientID(ctx, "c1")
+
+	// ASSERT
+	assert.NoError(t, userErr)
+	assert.Equal(t, int64(2), byUser)
+	assert.NoError(t, clientErr)
+	assert.Equal(t, int64(1), byClient)
+	total, err := store.CountAll(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), total)
+}
+
+func TestNewStore_ShouldClearExpiredAccessWithSplitExpiry(t *testing.T) {
+	// ARRANGE
+	store, err := NewStore(mysql.WithSplitExpiry(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	assert.NoError(t, store.Create(ctx, &models.Token{
+		Access:           "1_1_1",
+		AccessCreateAt:   time.Now().Add(-2 * time.Hour),
+		AccessExpiresIn:  time.Hour,
+		Refresh:          "2_2_2",
+		RefreshCreateAt:  time.Now(),
+		RefreshExpiresIn: time.Hour * 24,
+	}))
+
+	// ACTION
+	byAccess, accessErr := store.GetByAccess(ctx, "1_1_1")
+	n, purgeErr := store.PurgeExpired(ctx)
+
+	// ASSERT
+	assert.NoError(t, accessErr)
+	assert.Nil(t, byAccess)
+	assert.NoError(t, purgeErr)
+	assert.Equal(t, int64(0), n)
+	byRefresh, err := store.GetByRefresh(ctx, "2_2_2")
+	assert.NoError(t, err)
+	assert.NotNil(t, byRefresh)
+	assert.NoError(t, store.ForEach(ctx, func(item *mysql.StoreItem) error {
+		assert.Equal(t, "", item.Access)
+		assert.Equal(t, "2_2_2", item.Refresh)
+		return nil
+	}))
+}
+
+func TestNewStore_ShouldBuildExpiryHistogram(t *testing.T) {
+	// ARRANGE
+	store, err := NewStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	for i, expiresIn := range []time.Duration{30 * time.Minute, 2 * time.Hour, 48 * time.Hour} {
+		assert.NoError(t, store.Create(ctx, &models.Token{
+			Access:          fmt.Sprintf("access_%d", i),
+			AccessCreateAt:  time.Now(),
+			AccessExpiresIn: expiresIn,
+		}))
+	}
+
+	// ACTION
+	histogram, err := store.ExpiryHistogram(ctx, []time.Duration{time.Hour, 24 * time.Hour, 7 * 24 * time.Hour})
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, map[time.Duration]int64{time.Hour: 1, 24 * time.Hour: 2, 7 * 24 * time.Hour: 3}, histogram)
+}
+
+func TestNewStore_ShouldTruncateAll(t *testing.T) {
+	// ARRANGE
+	store, err := NewStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	assert.NoError(t, store.Create(ctx, &models.Token{Access: "1_1_1", AccessCreateAt: time.Now(), AccessExpiresIn: time.Hour}))
+
+	// ACTION
+	err = store.TruncateAll(ctx)
+
+	// ASSERT
+	assert.NoError(t, err)
+	total, err := store.CountAll(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), total)
+}
+
+func TestNewStore_ShouldEvictTokensExpiringFirstOverMaxRows(t *testing.T) {
+	// ARRANGE
+	store, err := NewStore(mysql.WithMaxRows(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	for i := 1; i <= 5; i++ {
+		assert.NoError(t, store.Create(ctx, &models.Token{
+			Access:          fmt.Sprintf("access_%d", i),
+			AccessCreateAt:  time.Now(),
+			AccessExpiresIn: time.Duration(i) * time.Hour,
+		}))
+	}
+
+	// ACTION
+	n, err := store.EvictOverflow(ctx)
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	for i := 1; i <= 5; i++ {
+		info, err := store.GetByAccess(ctx, fmt.Sprintf("access_%d", i))
+		assert.NoError(t, err)
+		assert.Equal(t, i > 2, info != nil, "access_%d", i)
+	}
+}
+
+func TestNewStore_ShouldCountRemovedTokens(t *testing.T) {
+	// ARRANGE
+	store, err := NewStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	assert.NoError(t, store.Create(ctx, &models.Token{Access: "1_1_1", AccessCreateAt: time.Now(), AccessExpiresIn: time.Hour}))
+
+	// ACTION
+	first, firstErr := store.RemoveByAccessCount(ctx, "1_1_1")
+	second, secondErr := store.RemoveByAccessCount(ctx, "1_1_1")
+
+	// ASSERT
+	assert.NoError(t, firstErr)
+	assert.Equal(t, int64(1), first)
+	assert.NoError(t, secondErr)
+	assert.Equal(t, int64(0), second)
+}
+
+func TestShardedStore_ShouldRouteTokensAcrossShards(t *testing.T) {
+	// ARRANGE
+	var shards []*mysql.Store
+	for i := 0; i < 3; i++ {
+		shard, err := NewStore()
+		if err != nil {
+			t.Fatal(err)
+		}
+		shards = append(shards, shard)
+	}
+	store, err := mysql.NewShardedStore(shards, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, store.Create(ctx, &models.Token{
+			Access:           fmt.Sprintf("access_%d", i),
+			AccessCreateAt:   time.Now(),
+			AccessExpiresIn:  time.Hour,
+			Refresh:          fmt.Sprintf("refresh_%d", i),
+			RefreshCreateAt:  time.Now(),
+			RefreshExpiresIn: time.Hour,
+		}))
+	}
+
+	// ACTION
+	byAccess, accessErr := store.GetByAccess(ctx, "access_3")
+	byRefresh, refreshErr := store.GetByRefresh(ctx, "refresh_7")
+	removeErr := store.RemoveByRefresh(ctx, "refresh_7")
+	removed, removedErr := store.GetByRefresh(ctx, "refresh_7")
+
+	// ASSERT
+	assert.NoError(t, accessErr)
+	if assert.NotNil(t, byAccess) {
+		assert.Equal(t, "refresh_3", byAccess.GetRefresh())
+	}
+	assert.NoError(t, refreshErr)
+	if assert.NotNil(t, byRefresh) {
+		assert.Equal(t, "access_7", byRefresh.GetAccess())
+	}
+	assert.NoError(t, removeErr)
+	assert.NoError(t, removedErr)
+	assert.Nil(t, removed)
+
+	var total int64
+	for _, shard := range shards {
+		n, err := shard.CountAll(ctx)
+		assert.NoError(t, err)
+		assert.Less(t, n, int64(10), "the tokens are spread over the shards")
+		total += n
+	}
+	assert.Equal(t, int64(10), total)
+}
+
+func TestNewStore_ShouldTouchAccessTokens(t *testing.T) {
+	// ARRANGE
+	store, err := NewStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	assert.NoError(t, store.Create(ctx, &models.Token{Access: "1_1_1", AccessCreateAt: time.Now(), AccessExpiresIn: time.Minute}))
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	// ACTION
+	touched, err := store.Touch(ctx, "1_1_1", expiry)
+	missing, missingErr := store.Touch(ctx, "2_2_2", expiry)
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.True(t, touched)
+	assert.NoError(t, missingErr)
+	assert.False(t, missing)
+	assert.NoError(t, store.ForEach(ctx, func(item *mysql.StoreItem) error {
+		assert.Equal(t, expiry.Unix(), item.ExpiredAt)
+		return nil
+	}))
+}
+
+func TestNewStore_ShouldEnforceTableConstraints(t *testing.T) {
+	// ARRANGE
+	store, err := NewStore(mysql.WithTableConstraints("CHECK (expired_at > 1600000000)"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	tx, err := store.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ACTION
+	_, insertErr := tx.Exec("INSERT INTO oauth2_token (expired_at, code, access, refresh, data, user_id) VALUES (1, '11_11_11', '', '', '{}', '')")
+	// the store has a single connection, release it before Create
+	assert.NoError(t, tx.Rollback())
+	createErr := store.Create(ctx, &models.Token{Access: "1_1_1", AccessCreateAt: time.Now(), AccessExpiresIn: time.Hour})
+
+	// ASSERT
+	assert.Error(t, insertErr)
+	assert.NoError(t, createErr)
+}
+
+func TestNewStore_ShouldKeepSoftDeletedTokensForTheRetention(t *testing.T) {
+	// ARRANGE
+	now := time.Now()
+	clock := func() time.Time { return now }
+	store, err := NewStore(mysql.WithSoftDelete(30*24*time.Hour), mysql.WithNowFunc(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	assert.NoError(t, store.Create(ctx, &models.Token{
+		Access:           "1_1_1",
+		AccessCreateAt:   now,
+		AccessExpiresIn:  time.Hour,
+		Refresh:          "2_2_2",
+		RefreshCreateAt:  now,
+		RefreshExpiresIn: 365 * 24 * time.Hour,
+	}))
+
+	// ACTION
+	assert.NoError(t, store.RemoveByAccess(ctx, "1_1_1"))
+
+	// ASSERT
+	got, err := store.GetByRefresh(ctx, "2_2_2")
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+	item, err := store.GetItemByAccess(ctx, "1_1_1")
+	if assert.NoError(t, err) && assert.NotNil(t, item) {
+		assert.Equal(t, now.Unix(), item.DeletedAt)
+		assert.Equal(t, "2_2_2", item.Refresh)
+	}
+
+	now = now.Add(30*24*time.Hour - time.Second)
+	n, err := store.PurgeExpired(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), n, "within the retention")
+
+	now = now.Add(time.Second)
+	n, err = store.PurgeExpired(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n, "once the retention ended")
+}
+
+func TestNewStore_ShouldPurgeExpiredRowsRegardlessOfSoftDeletes(t *testing.T) {
+	// ARRANGE
+	now := time.Now()
+	clock := func() time.Time { return now }
+	store, err := NewStore(mysql.WithSoftDelete(30*24*time.Hour), mysql.WithNowFunc(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	for _, access := range []string{"1_1_1", "2_2_2"} {
+		assert.NoError(t, store.Create(ctx, &models.Token{
+			Access:          access,
+			AccessCreateAt:  now,
+			AccessExpiresIn: time.Hour,
+		}))
+	}
+	assert.NoError(t, store.RemoveByAccess(ctx, "2_2_2"))
+
+	// ACTION
+	now = now.Add(2 * time.Hour)
+	n, err := store.PurgeExpired(ctx)
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	item, err := store.GetItemByAccess(ctx, "2_2_2")
+	if assert.NoError(t, err) {
+		assert.NotNil(t, item, "the revoked row is kept for the retention")
+	}
+	item, err = store.GetItemByAccess(ctx, "1_1_1")
+	assert.NoError(t, err)
+	assert.Nil(t, item)
+}
+
+func TestNewStore_ShouldRecreateMissingTableWhileInUse(t *testing.T) {
+	// ARRANGE
+	store, err := NewStore(mysql.WithGCMissingTable(mysql.MissingTableRecreate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	tx, err := store.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tx.Exec("DROP TABLE oauth2_token")
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit())
+
+	// ACTION, the gc re-creates the table while the store is in use
+	store.SetGCInterval(time.Millisecond)
+	info := &models.Token{Access: "1_1_1", AccessCreateAt: time.Now(), AccessExpiresIn: time.Hour}
+
+	// ASSERT
+	assert.Eventually(t, func() bool {
+		_, _ = store.GetByAccess(ctx, "1_1_1")
+		return store.Create(ctx, info) == nil
+	}, 5*time.Second, time.Millisecond)
+}
+
+// newSoftDeleteStore returns a soft deleting store holding a revoked and
+// a live access token, both expired an hour after now
+func newSoftDeleteStore(t *testing.T, now *time.Time, opts ...mysql.Option) *mysql.Store {
+	clock := func() time.Time { return *now }
+	store, err := NewStore(append([]mysql.Option{mysql.WithSoftDelete(30 * 24 * time.Hour), mysql.WithNowFunc(clock)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, access := range []string{"revoked", "live"} {
+		assert.NoError(t, store.Create(ctx, &models.Token{
+			Access:          access,
+			AccessCreateAt:  *now,
+			AccessExpiresIn: time.Hour,
+		}))
+	}
+	assert.NoError(t, store.RemoveByAccess(ctx, "revoked"))
+	return store
+}
+
+func TestRemoveExpiredBefore_ShouldKeepSoftDeletedRowsWithinRetention(t *testing.T) {
+	// ARRANGE
+	now := time.Now()
+	store := newSoftDeleteStore(t, &now)
+	defer store.Close()
+	ctx := context.Background()
+
+	// ACTION
+	now = now.Add(2 * time.Hour)
+	n, err := store.RemoveExpiredBefore(ctx, now)
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	item, err := store.GetItemByAccess(ctx, "revoked")
+	assert.NoError(t, err)
+	assert.NotNil(t, item)
+}
+
+func TestEvictOverflow_ShouldKeepSoftDeletedRowsWithinRetention(t *testing.T) {
+	// ARRANGE
+	now := time.Now()
+	store := newSoftDeleteStore(t, &now, mysql.WithMaxRows(1))
+	defer store.Close()
+	ctx := context.Background()
+
+	// ACTION
+	n, err := store.EvictOverflow(ctx)
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	item, err := store.GetItemByAccess(ctx, "revoked")
+	assert.NoError(t, err)
+	assert.NotNil(t, item)
+	item, err = store.GetItemByAccess(ctx, "live")
+	assert.NoError(t, err)
+	assert.Nil(t, item)
+}
+
+func TestPurgeRemoved_ShouldKeepSoftDeletedRowsWithinRetention(t *testing.T) {
+	// ARRANGE
+	now := time.Now()
+	store := newSoftDeleteStore(t, &now)
+	defer store.Close()
+	ctx := context.Background()
+
+	// a row revoked with every token cleared, as rows cleared before the
+	// store turned to soft deletes
+	tx, err := store.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tx.Exec("INSERT INTO oauth2_token (expired_at, code, access, refresh, data, user_id, deleted_at) VALUES (?, '', '', '', '{}', 'revoked', ?)",
+		now.Add(time.Hour).Unix(), now.Unix())
+	assert.NoError(t, err)
+	_, err = tx.Exec("INSERT INTO oauth2_token (expired_at, code, access, refresh, data, user_id, deleted_at) VALUES (?, '', '', '', '{}', 'live', 0)",
+		now.Add(time.Hour).Unix())
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit())
+
+	// ACTION
+	n, err := store.PurgeRemoved(ctx)
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	total, err := store.CountAll(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), total)
+}
@@ -0,0 +1,131 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"gopkg.in/gorp.v2"
+)
+
+// stmtCache prepared statements of the lookups and removals by token on
+// one database, see WithStatementCache. Only the fixed queries of the
+// store are cached, so the cache never grows past them.
+type stmtCache struct {
+	db        *sql.DB
+	cacheable map[string]bool
+	stmts     sync.Map
+	// mu guards retired, the statements dropped after a connection error
+	// and closed with the cache
+	mu      sync.Mutex
+	retired []*sql.Stmt
+}
+
+// newStmtCache create the statement cache of db for the lookup and
+// removal queries of the store
+func (s *Store) newStmtCache(db *sql.DB) *stmtCache {
+	cacheable := make(map[string]bool)
+	for _, queries := range []map[string]string{s.queries.getToken, s.queries.getItem, s.queries.remove} {
+		for _, query := range queries {
+			cacheable[query] = true
+		}
+	}
+	return &stmtCache{db: db, cacheable: cacheable}
+}
+
+// stmt returns the prepared statement of the query, preparing it on
+// first use, nil for a query that is not cached
+func (c *stmtCache) stmt(ctx context.Context, query string) (*sql.Stmt, error) {
+	if !c.cacheable[query] {
+		return nil, nil
+	}
+	if stmt, ok := c.stmts.Load(query); ok {
+		return stmt.(*sql.Stmt), nil
+	}
+	stmt, err := c.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if prev, loaded := c.stmts.LoadOrStore(query, stmt); loaded {
+		_ = stmt.Close()
+		return prev.(*sql.Stmt), nil
+	}
+	return stmt, nil
+}
+
+// failed drops the statement after a connection error, so the next call
+// prepares it again. The statement may still be in use by concurrent
+// calls, it is only closed with the cache.
+func (c *stmtCache) failed(query string, stmt *sql.Stmt, err error) {
+	if !isTransient(err) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cur, ok := c.stmts.Load(query); ok && cur == stmt {
+		c.stmts.Delete(query)
+		c.retired = append(c.retired, stmt)
+	}
+}
+
+// close closes the cached and dropped statements
+func (c *stmtCache) close() {
+	c.stmts.Range(func(query, stmt interface{}) bool {
+		_ = stmt.(*sql.Stmt).Close()
+		c.stmts.Delete(query)
+		return true
+	})
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, stmt := range c.retired {
+		_ = stmt.Close()
+	}
+	c.retired = nil
+}
+
+// stmtExecutor runs the cached queries of a database through their
+// prepared statements. gorp maps the rows through the DbMap but reads
+// them with the Query of the executor it is given, which is why
+// SelectOne, the select of the cached lookups, is redirected to the gorp
+// function taking an executor.
+type stmtExecutor struct {
+	gorp.SqlExecutor
+	dbmap *gorp.DbMap
+	ctx   context.Context
+	cache *stmtCache
+}
+
+func (e *stmtExecutor) WithContext(ctx context.Context) gorp.SqlExecutor {
+	ex := e.SqlExecutor.WithContext(ctx)
+	return &stmtExecutor{SqlExecutor: ex, dbmap: ex.(*gorp.DbMap), ctx: ctx, cache: e.cache}
+}
+
+func (e *stmtExecutor) Exec(query string, args ...interface{}) (sql.Result, error) {
+	stmt, err := e.cache.stmt(e.ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if stmt == nil {
+		return e.SqlExecutor.Exec(query, args...)
+	}
+	res, err := stmt.ExecContext(e.ctx, args...)
+	e.cache.failed(query, stmt, err)
+	return res, err
+}
+
+func (e *stmtExecutor) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	stmt, err := e.cache.stmt(e.ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if stmt == nil {
+		return e.SqlExecutor.Query(query, args...)
+	}
+	rows, err := stmt.QueryContext(e.ctx, args...)
+	e.cache.failed(query, stmt, err)
+	return rows, err
+}
+
+func (e *stmtExecutor) SelectOne(holder interface{}, query string, args ...interface{}) error {
+	return gorp.SelectOne(e.dbmap, e, holder, query, args...)
+}
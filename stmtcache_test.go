@@ -0,0 +1,65 @@
+package mysql
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithStatementCache_ShouldPrepareLookupsOnce(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithStatementCache(true))
+
+	columns := []string{"id", "expired_at", "code", "access", "refresh", "data", "user_id"}
+	expiry := time.Now().Add(time.Hour).Unix()
+	prepare := mockDB.ExpectPrepare(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE access=? AND expired_at>? LIMIT 1")).
+		WillBeClosed()
+	prepare.ExpectQuery().
+		WithArgs("1_1_1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(1, expiry, "", "1_1_1", "", `{"Access":"1_1_1"}`, ""))
+	prepare.ExpectQuery().
+		WithArgs("2_2_2", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(columns))
+
+	// ACTION
+	info, err := store.GetByAccess(context.Background(), "1_1_1")
+	missing, missingErr := store.GetByAccess(context.Background(), "2_2_2")
+	store.Close()
+
+	// ASSERT
+	assert.NoError(t, err)
+	if assert.NotNil(t, info) {
+		assert.Equal(t, "1_1_1", info.GetAccess())
+	}
+	assert.NoError(t, missingErr)
+	assert.Nil(t, missing)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestWithStatementCache_ShouldPrepareAgainAfterConnectionError(t *testing.T) {
+	// ARRANGE
+	store, mockDB := newMockStore(t, WithStatementCache(true), WithRetry(1, time.Millisecond))
+	defer store.Close()
+
+	query := regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE code=? AND expired_at>? LIMIT 1")
+	mockDB.ExpectPrepare(query).
+		ExpectQuery().
+		WithArgs("11_11_11", sqlmock.AnyArg()).
+		WillReturnError(mysqldriver.ErrInvalidConn)
+	mockDB.ExpectPrepare(query).
+		ExpectQuery().
+		WithArgs("11_11_11", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	// ACTION
+	_, err := store.GetByCode(context.Background(), "11_11_11")
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
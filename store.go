@@ -1,179 +1,1660 @@
 package mysql
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"database/sql"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/go-oauth2/oauth2/v4"
 	"github.com/go-oauth2/oauth2/v4/models"
-	jsoniter "github.com/json-iterator/go"
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/gorp.v2"
 )
 
+// gcBatchPause pause between two gc delete batches
+var gcBatchPause = time.Millisecond * 10
+
+// Store implements the token store of the oauth2 manager, the build
+// breaks should a method signature drift from the interface
+var _ oauth2.TokenStore = (*Store)(nil)
+
 // Store mysql token store
 type Store struct {
-	tableName string
-	db        *gorp.DbMap
-	stdout    io.Writer
-	ticker    *time.Ticker
+	tableName       string
+	db              *gorp.DbMap
+	tableMap        *gorp.TableMap
+	readDB          *gorp.DbMap
+	sharedDB        bool
+	logger          Logger
+	logLevel        LogLevel
+	metrics         Metrics
+	audit           AuditHooks
+	tracer          trace.Tracer
+	tokenFactory    func() oauth2.TokenInfo
+	cipher          Cipher
+	codec           Codec
+	now             func() time.Time
+	compress        bool
+	ticker          *time.Ticker
+	gcInterval      time.Duration
+	gcJitter        time.Duration
+	gcDryRun        bool
+	gcMissingTable  MissingTableAction
+	tableMissing    bool
+	gcRand          *rand.Rand
+	gcBatchSize     int
+	gcUnordered     bool
+	gcResetAutoInc  bool
+	insertBatchSize int
+	hardDelete      bool
+	softDelete      bool
+	softRetention   time.Duration
+	uuidKeys        bool
+	clientIDColumn  bool
+	splitExpiry     bool
+	skipCreate      bool
+	readExpired     bool
+	tenantID        string
+	notFoundError   bool
+	tokenNotFound   bool
+	indexes         []Index
+	uniqueTokens    bool
+	nullTokens      bool
+	sizes           ColumnSizes
+	queryLogger     QueryLogger
+	unsafeArgs      bool
+	redactor        Redactor
+	cacheStmts      bool
+	stmts           map[*gorp.DbMap]*stmtCache
+	maxRows         int
+	skipCorrupt     bool
+	queries         queries
+	dataType        string
+	rowFormat       string
+	keyBlockSize    int
+	constraints     []string
+	typeConverter   gorp.TypeConverter
+	queryTimeout    time.Duration
+	maxRetries      int
+	pingAttempts    int
+	pingBackoff     time.Duration
+	retryBackoff    time.Duration
+	done            chan struct{}
+	closeOnce       sync.Once
+	// mu guards ticker, gcInterval, gcRand, gcDryRun and logger once the
+	// store is running
+	mu sync.Mutex
+	// gcCtx is cancelled on close, gcRunning tracks the gc goroutine
+	gcCtx     context.Context
+	gcCancel  context.CancelFunc
+	gcRunning sync.WaitGroup
 }
 
-// SetStdout set error output
+// SetStdout set error output, see SetLogger
 func (s *Store) SetStdout(stdout io.Writer) *Store {
-	s.stdout = stdout
+	if stdout == nil {
+		return s.SetLogger(nil)
+	}
+	return s.SetLogger(NewWriterLogger(stdout))
+}
+
+// SetLogger set the logger used to report errors, nil disables logging.
+// It is safe to call while the gc is running.
+func (s *Store) SetLogger(logger Logger) *Store {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logger = logger
 	return s
 }
 
-// Close close the store
+// currentLogger returns the logger set by SetLogger
+func (s *Store) currentLogger() Logger {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logger
+}
+
+// Close close the store, stopping the gc goroutine and cancelling
+// a gc cycle in progress. It is safe to call Close more than once.
 func (s *Store) Close() {
-	s.ticker.Stop()
-	_ = s.db.Db.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = s.CloseContext(ctx)
+}
+
+// CloseContext close the store like Close, but first waits for a gc
+// cycle in progress to finish. When ctx is done before, the cycle is
+// cancelled and ctx.Err() returned. The databases are closed either way.
+func (s *Store) CloseContext(ctx context.Context) error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		if s.ticker != nil {
+			s.ticker.Stop()
+		}
+		close(s.done)
+		s.mu.Unlock()
+
+		drained := make(chan struct{})
+		go func() {
+			s.gcRunning.Wait()
+			close(drained)
+		}()
+		select {
+		case <-drained:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		s.gcCancel()
+
+		for _, cache := range s.stmts {
+			cache.close()
+		}
+		if !s.sharedDB {
+			_ = s.db.Db.Close()
+		}
+		if s.readDB != s.db {
+			_ = s.readDB.Db.Close()
+		}
+	})
+	return err
+}
+
+// Ping verifies the connections to the database are alive
+func (s *Store) Ping(ctx context.Context) error {
+	if err := s.db.Db.PingContext(ctx); err != nil {
+		return err
+	}
+	if s.readDB != s.db {
+		return s.readDB.Db.PingContext(ctx)
+	}
+	return nil
+}
+
+// Stats returns the connection pool statistics of the (write) database
+func (s *Store) Stats() sql.DBStats {
+	return s.db.Db.Stats()
+}
+
+// primaryKey context key of ReadFromPrimary
+type primaryKey struct{}
+
+// ReadFromPrimary returns a context making the lookups of the store run
+// on the write database rather than the read one, for read-your-writes
+// consistency where a token created a moment ago may not have reached
+// the replica yet, such as the token exchange that follows an
+// authorization code. The primary is usually farther and busier than the
+// replicas; keep it to the calls that need it.
+func ReadFromPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// reader returns the database the lookups run on, the read one unless
+// ctx comes from ReadFromPrimary
+func (s *Store) reader(ctx context.Context) *gorp.DbMap {
+	if primary, _ := ctx.Value(primaryKey{}).(bool); primary {
+		return s.db
+	}
+	return s.readDB
+}
+
+// scope restricts the query condition to the store's tenant, if any
+func (s *Store) scope(cond string, args ...interface{}) (string, []interface{}) {
+	if s.tenantID == "" {
+		return cond, args
+	}
+	if strings.Contains(cond, " OR ") {
+		cond = "(" + cond + ")"
+	}
+	return cond + " AND tenant_id=?", s.scopeArgs(args...)
+}
+
+// scopeArgs appends the tenant id to the arguments of a query scoped
+// when it was built, see queries
+func (s *Store) scopeArgs(args ...interface{}) []interface{} {
+	if s.tenantID == "" {
+		return args
+	}
+	return append(args, s.tenantID)
+}
+
+// TableName returns the name of the token table
+func (s *Store) TableName() string {
+	return s.tableName
+}
+
+// table returns the quoted table name for use in queries
+func (s *Store) table() string {
+	return s.db.Dialect.QuotedTableForQuery("", s.tableName)
+}
+
+// SetGCInterval changes the gc interval of the running store, the next
+// cycle runs one interval from now. A gc cycle in progress is not
+// interrupted. A non positive interval pauses the gc, a positive one
+// resumes it, starting it when the store was created with gc disabled.
+func (s *Store) SetGCInterval(interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+
+	s.gcInterval = interval
+	switch {
+	case interval <= 0:
+		if s.ticker != nil {
+			s.ticker.Stop()
+		}
+	case s.ticker == nil:
+		s.startGC()
+	default:
+		s.ticker.Reset(s.nextGCInterval())
+	}
+}
+
+// SetGCDryRun toggles the gc dry run of the running store: the gc cycles
+// only count the rows they would delete and log the count, see WithGCDryRun
+func (s *Store) SetGCDryRun(dryRun bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gcDryRun = dryRun
+}
+
+// startGC starts the gc goroutine
+func (s *Store) startGC() {
+	if s.gcJitter > 0 {
+		s.gcRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	s.ticker = time.NewTicker(s.nextGCInterval())
+	s.gcRunning.Add(1)
+	go s.gc(s.ticker)
+}
+
+// nextGCInterval returns the gc interval plus a random jitter,
+// the caller holds mu
+func (s *Store) nextGCInterval() time.Duration {
+	if s.gcJitter <= 0 {
+		return s.gcInterval
+	}
+	return s.gcInterval + time.Duration(s.gcRand.Int63n(int64(s.gcJitter)))
+}
+
+func (s *Store) gc(ticker *time.Ticker) {
+	defer s.gcRunning.Done()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.clean()
+			s.rearmGC(ticker)
+		}
+	}
 }
 
-func (s *Store) gc() {
-	for range s.ticker.C {
-		s.clean()
+// rearmGC draws a new jittered interval for the next gc cycle
+func (s *Store) rearmGC(ticker *time.Ticker) {
+	if s.gcJitter <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	if s.gcInterval > 0 {
+		ticker.Reset(s.nextGCInterval())
 	}
 }
 
+// clean runs one gc cycle. A failed cycle is only logged, the gc loop
+// carries on with the next tick so cleanup resumes once the db recovers.
+// A missing table is handled by WithGCMissingTable.
 func (s *Store) clean() {
-	now := time.Now().Unix()
-	query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE expired_at<=? OR (code='' AND access='' AND refresh='')", s.tableName)
-	n, err := s.db.SelectInt(query, now)
-	if err != nil || n == 0 {
+	s.mu.Lock()
+	dryRun := s.gcDryRun
+	s.mu.Unlock()
+
+	start := time.Now()
+	var n int64
+	var err error
+	if dryRun {
+		n, err = s.countPurgeable(s.gcCtx)
+		if err == nil {
+			s.infof("gc dry run: would delete %d rows from %s", n, s.tableName)
+		}
+		if err == nil && s.splitExpiry {
+			var cleared int64
+			if cleared, err = s.countExpiredAccess(s.gcCtx); err == nil {
+				s.infof("gc dry run: would clear %d expired access tokens in %s", cleared, s.tableName)
+			}
+		}
+	} else {
+		n, err = s.PurgeExpired(s.gcCtx)
+		if err == nil && s.maxRows > 0 {
+			var evicted int64
+			evicted, err = s.EvictOverflow(s.gcCtx)
+			if evicted > 0 {
+				s.infof("gc evicted %d rows from %s over the %d rows cap", evicted, s.tableName, s.maxRows)
+			}
+			n += evicted
+		}
+		if err == nil && n > 0 && s.gcResetAutoInc {
+			var reset bool
+			if reset, err = s.resetEmptyAutoIncrement(s.gcCtx); reset && err == nil {
+				s.infof("gc reset the autoincrement of the empty table %s", s.tableName)
+			}
+		}
+	}
+	switch {
+	case err != nil && s.gcCtx.Err() != nil:
+		// cancelled by Close, the shutdown is not an error
+	case isMissingTable(err):
+		s.missingTable(err)
+	case err != nil:
+		s.errorf("%s", err)
+	case dryRun:
+	case n > 0:
+		s.infof("gc deleted %d rows from %s", n, s.tableName)
+	default:
+		s.debugf("gc deleted no rows from %s", s.tableName)
+	}
+	if err == nil {
+		s.tableMissing = false
+	}
+	s.observeGC(n, time.Since(start), err)
+}
+
+// missingTable handles a gc cycle failing on a missing token table
+// according to WithGCMissingTable, rather than logging the same error
+// every tick
+func (s *Store) missingTable(err error) {
+	switch s.gcMissingTable {
+	case MissingTableStop:
+		s.errorf("gc stopped: table %s does not exist: %s", s.tableName, err)
+		s.SetGCInterval(0)
+	case MissingTableRecreate:
+		// the table stays registered, only the DDL runs again
+		if createErr := s.createTables(); createErr != nil {
+			s.errorf("gc stopped: can't re-create missing table %s: %s", s.tableName, createErr)
+			s.SetGCInterval(0)
+			return
+		}
+		s.infof("gc re-created missing table %s", s.tableName)
+	default:
+		if !s.tableMissing {
+			s.errorf("gc: table %s does not exist, retrying silently until it does: %s", s.tableName, err)
+		}
+		s.tableMissing = true
+	}
+}
+
+// PurgeExpired delete the expired and fully removed token rows,
+// returning the number of rows deleted
+func (s *Store) PurgeExpired(ctx context.Context) (int64, error) {
+	ctx, op := s.startOp(ctx, "PurgeExpired")
+	n, err := s.purgeExpired(ctx)
+	op.setRowsAffected(n)
+	op.end(err)
+	return n, err
+}
+
+func (s *Store) purgeExpired(ctx context.Context) (int64, error) {
+	now := s.now().Unix()
+	n, err := s.execInBatches(ctx, s.queries.purge, s.purgeArgs(now)...)
+	if err != nil || !s.splitExpiry {
+		return n, err
+	}
+	_, err = s.clearExpiredAccess(ctx, now)
+	return n, err
+}
+
+// clearExpiredAccess clears the access tokens that expired at or before
+// now in the rows kept alive by their refresh token, see WithSplitExpiry
+func (s *Store) clearExpiredAccess(ctx context.Context, now int64) (int64, error) {
+	return s.execInBatches(ctx, s.queries.clearAccess, s.scopeArgs(now, now)...)
+}
+
+// expiredAccessCond matches the rows whose access token expired while the
+// row itself did not
+func (s *Store) expiredAccessCond() string {
+	return fmt.Sprintf("access_expired_at<=? AND expired_at>? AND NOT (%s)", s.isEmptyToken("access"))
+}
+
+// EvictOverflow deletes the rows beyond the WithMaxRows cap, those
+// expiring first, returning the number of rows evicted. The gc calls it
+// after every cycle, it is a no-op without a cap.
+func (s *Store) EvictOverflow(ctx context.Context) (int64, error) {
+	if s.maxRows <= 0 {
+		return 0, nil
+	}
+	ctx, op := s.startOp(ctx, "EvictOverflow")
+	n, err := s.evictOverflow(ctx)
+	op.setRowsAffected(n)
+	op.end(err)
+	return n, err
+}
+
+func (s *Store) evictOverflow(ctx context.Context) (int64, error) {
+	db := s.conn(ctx, s.db)
+	total, err := db.SelectInt(s.queries.countRows, s.scopeArgs()...)
+	if err != nil {
+		return 0, ctxErr(ctx, err)
+	}
+	var evicted int64
+	for excess := total - int64(s.maxRows); evicted < excess; {
+		batch := excess - evicted
+		if batch > int64(s.gcBatchSize) {
+			batch = int64(s.gcBatchSize)
+		}
+		res, err := db.Exec(s.queries.evict, s.evictArgs(batch)...)
 		if err != nil {
-			s.errorf(err.Error())
+			return evicted, ctxErr(ctx, err)
 		}
-		return
+		n, err := res.RowsAffected()
+		if err != nil {
+			return evicted, err
+		}
+		if n == 0 {
+			break
+		}
+		evicted += n
+	}
+	return evicted, nil
+}
+
+// evictArgs returns the arguments of the evict statement, the tenant and
+// the end of the retention of its condition before the batch size
+func (s *Store) evictArgs(batch int64) []interface{} {
+	var args []interface{}
+	if s.tenantID != "" {
+		args = append(args, s.tenantID)
 	}
+	if s.softDelete {
+		args = append(args, s.retentionEnd(s.now().Unix()))
+	}
+	return append(args, batch)
+}
+
+// PurgeRemoved delete the rows whose code, access and refresh tokens were
+// all removed, whatever their expiry, returning the number of rows
+// deleted. Such rows can never be looked up again. The gc already deletes
+// them along with the expired rows, PurgeRemoved is for stores running
+// with the gc disabled or between gc cycles.
+func (s *Store) PurgeRemoved(ctx context.Context) (int64, error) {
+	ctx, op := s.startOp(ctx, "PurgeRemoved")
+	cond := fmt.Sprintf("%s AND %s AND %s",
+		s.isEmptyToken("code"), s.isEmptyToken("access"), s.isEmptyToken("refresh"))
+	n, err := s.deleteInBatches(ctx, cond)
+	op.setRowsAffected(n)
+	op.end(err)
+	return n, err
+}
+
+// countPurgeable counts the rows PurgeExpired would delete
+func (s *Store) countPurgeable(ctx context.Context) (int64, error) {
+	n, err := s.conn(ctx, s.db).SelectInt(s.queries.countPurgeable, s.purgeArgs(s.now().Unix())...)
+	return n, ctxErr(ctx, err)
+}
+
+// countExpiredAccess counts the access tokens clearExpiredAccess would clear
+func (s *Store) countExpiredAccess(ctx context.Context) (int64, error) {
+	now := s.now().Unix()
+	n, err := s.conn(ctx, s.db).SelectInt(s.queries.countAccess, s.scopeArgs(now, now)...)
+	return n, ctxErr(ctx, err)
+}
+
+// purgeCond matches the expired rows and the rows with every token removed
+func (s *Store) purgeCond() string {
+	return fmt.Sprintf("expired_at<=? OR (%s AND %s AND %s)",
+		s.isEmptyToken("code"), s.isEmptyToken("access"), s.isEmptyToken("refresh"))
+}
+
+// RemoveExpiredBefore delete the token rows that expired at or before t,
+// leaving newer ones alone, returning the number of rows deleted
+func (s *Store) RemoveExpiredBefore(ctx context.Context, t time.Time) (int64, error) {
+	ctx, op := s.startOp(ctx, "RemoveExpiredBefore")
+	n, err := s.deleteInBatches(ctx, "expired_at<=?", t.Unix())
+	op.setRowsAffected(n)
+	op.end(err)
+	return n, err
+}
 
-	_, err = s.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE expired_at<=? OR (code='' AND access='' AND refresh='')", s.tableName), now)
+// deleteInBatches deletes the rows matching the condition gcBatchSize rows
+// at a time, keeping every statement (and its locks) small. On MySQL the
+// rows are deleted in expired_at order so InnoDB walks idx_expired_at.
+func (s *Store) deleteInBatches(ctx context.Context, cond string, args ...interface{}) (int64, error) {
+	cond, args = s.retained(cond, args...)
+	cond, args = s.scope(cond, args...)
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s ORDER BY expired_at LIMIT ?", s.table(), cond)
+	if !s.isMySQL() {
+		query = fmt.Sprintf("DELETE FROM %s WHERE id IN (SELECT id FROM %s WHERE %s LIMIT ?)", s.table(), s.table(), cond)
+	}
+	return s.execInBatches(ctx, query, args...)
+}
+
+// execInBatches runs the statement, whose last placeholder is the batch
+// size, until it changes fewer than gcBatchSize rows, pausing between
+// batches. It returns the total number of rows changed.
+func (s *Store) execInBatches(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	db := s.conn(ctx, s.db)
+	args = append(args, s.gcBatchSize)
+	var total int64
+	for {
+		res, err := db.Exec(query, args...)
+		if err != nil {
+			return total, ctxErr(ctx, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n < int64(s.gcBatchSize) {
+			return total, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return total, ctx.Err()
+		case <-time.After(gcBatchPause):
+		}
+	}
+}
+
+// TruncateAll deletes every row of the token table, such as between the
+// cases of an integration test reusing a database. It only ever targets
+// the store's own table, refusing tenant scoped stores whose table holds
+// the rows of other tenants. MySQL truncates the table, resetting the
+// autoincrement id, other dialects delete the rows.
+func (s *Store) TruncateAll(ctx context.Context) error {
+	if s.tenantID != "" {
+		return errors.New("mysql: truncate: the table of a tenant scoped store is shared with other tenants")
+	}
+	if !identifierRegexp.MatchString(s.tableName) {
+		return fmt.Errorf("mysql: truncate: invalid table name %q", s.tableName)
+	}
+	ctx, op := s.startOp(ctx, "TruncateAll")
+	query := "TRUNCATE TABLE " + s.table()
+	if !s.isMySQL() {
+		query = "DELETE FROM " + s.table()
+	}
+	_, err := s.conn(ctx, s.db).Exec(query)
+	err = ctxErr(ctx, err)
+	op.end(err)
+	return err
+}
+
+// DeleteAll deletes every token row, expired or not, returning the
+// number of rows deleted. It is destructive, meant for tests and load
+// test teardowns. Unlike TruncateAll it only needs the DELETE privilege,
+// and a tenant scoped store only deletes the rows of its tenant.
+func (s *Store) DeleteAll(ctx context.Context) (int64, error) {
+	ctx, op := s.startOp(ctx, "DeleteAll")
+	query := "DELETE FROM " + s.table()
+	if s.tenantID != "" {
+		query += " WHERE tenant_id=?"
+	}
+	res, err := s.conn(ctx, s.db).Exec(query, s.scopeArgs()...)
+	var n int64
+	if err == nil {
+		n, err = res.RowsAffected()
+	}
+	err = ctxErr(ctx, err)
+	op.setRowsAffected(n)
+	op.end(err)
+	return n, err
+}
+
+// Count returns the number of token rows that have not expired yet
+func (s *Store) Count(ctx context.Context) (int64, error) {
+	where, args := s.scope(s.notDeleted("expired_at>?"), s.now().Unix())
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", s.table(), where)
+	n, err := s.conn(ctx, s.reader(ctx)).SelectInt(query, args...)
+	return n, ctxErr(ctx, err)
+}
+
+// ExpiryHistogram returns, for every bucket, the number of token rows
+// that have not expired yet and expire within the bucket duration from
+// now, so a 24h bucket includes the tokens of a 1h one. The counts come
+// from a single query over idx_expired_at.
+func (s *Store) ExpiryHistogram(ctx context.Context, buckets []time.Duration) (map[time.Duration]int64, error) {
+	histogram := make(map[time.Duration]int64, len(buckets))
+	if len(buckets) == 0 {
+		return histogram, nil
+	}
+	now := s.now()
+	var longest time.Duration
+	counts := make([]string, len(buckets))
+	args := make([]interface{}, 0, len(buckets)+2)
+	for i, bucket := range buckets {
+		if bucket <= 0 {
+			return nil, fmt.Errorf("mysql: expiry histogram: invalid bucket %s", bucket)
+		}
+		if bucket > longest {
+			longest = bucket
+		}
+		counts[i] = "COUNT(CASE WHEN expired_at<=? THEN 1 END)"
+		args = append(args, now.Add(bucket).Unix())
+	}
+	where, whereArgs := s.scope(s.notDeleted("expired_at>? AND expired_at<=?"), now.Unix(), now.Add(longest).Unix())
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s", strings.Join(counts, ", "), s.table(), where)
+
+	values := make([]int64, len(buckets))
+	dest := make([]interface{}, len(buckets))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	row := s.conn(ctx, s.reader(ctx)).QueryRow(query, append(args, whereArgs...)...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, fmt.Errorf("mysql: expiry histogram: %w", ctxErr(ctx, err))
+	}
+	for i, bucket := range buckets {
+		histogram[bucket] = values[i]
+	}
+	return histogram, nil
+}
+
+// CountAll returns the number of token rows, expired or not
+func (s *Store) CountAll(ctx context.Context) (int64, error) {
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s", s.table())
+	var args []interface{}
+	if s.tenantID != "" {
+		query += " WHERE tenant_id=?"
+		args = append(args, s.tenantID)
+	}
+	n, err := s.conn(ctx, s.reader(ctx)).SelectInt(query, args...)
+	return n, ctxErr(ctx, err)
+}
+
+// List returns a page of the raw token rows ordered by id, expired or not,
+// along with the total number of rows. A non positive limit only returns
+// the total.
+func (s *Store) List(ctx context.Context, offset, limit int) ([]StoreItem, int64, error) {
+	total, err := s.CountAll(ctx)
+	if err != nil || limit <= 0 {
+		return nil, total, err
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	query := fmt.Sprintf("SELECT * FROM %s", s.table())
+	var args []interface{}
+	if s.tenantID != "" {
+		query += " WHERE tenant_id=?"
+		args = append(args, s.tenantID)
+	}
+	query += " ORDER BY id LIMIT ? OFFSET ?"
+	args = append(args, limit, offset)
+	if s.uuidKeys {
+		var rows []UUIDStoreItem
+		if _, err := s.conn(ctx, s.reader(ctx)).Select(&rows, query, args...); err != nil {
+			return nil, total, ctxErr(ctx, err)
+		}
+		items := make([]StoreItem, len(rows))
+		for i := range rows {
+			items[i] = rows[i].storeItem()
+		}
+		return items, total, nil
+	}
+	var items []StoreItem
+	if _, err := s.conn(ctx, s.reader(ctx)).Select(&items, query, args...); err != nil {
+		return nil, total, ctxErr(ctx, err)
+	}
+	return items, total, nil
+}
+
+// ListTokens returns the decoded token information of a page of rows like
+// List, keeping only the tokens filter accepts (nil keeps all of them).
+// The filter runs after the page is read, so a page can hold fewer than
+// limit tokens while later pages still have matches.
+func (s *Store) ListTokens(ctx context.Context, offset, limit int, filter func(oauth2.TokenInfo) bool) ([]oauth2.TokenInfo, int64, error) {
+	items, total, err := s.List(ctx, offset, limit)
 	if err != nil {
-		s.errorf(err.Error())
+		return nil, total, err
+	}
+
+	infos := make([]oauth2.TokenInfo, 0, len(items))
+	for i := range items {
+		info, err := s.itemTokenInfo(&items[i])
+		if errors.Is(err, ErrCorruptToken) && s.skipCorrupt {
+			continue
+		}
+		if err != nil {
+			return nil, total, err
+		}
+		if filter == nil || filter(info) {
+			infos = append(infos, info)
+		}
 	}
+	return infos, total, nil
+}
+
+// ForEach streams every token row in id order to fn without loading the
+// table into memory, stopping at the first error returned by fn or when
+// ctx is done. Removed tokens are passed as empty strings.
+func (s *Store) ForEach(ctx context.Context, fn func(*StoreItem) error) error {
+	columns := "id, expired_at, code, access, refresh, data, user_id"
+	if s.clientIDColumn {
+		columns += ", client_id"
+	}
+	if s.splitExpiry {
+		columns += ", access_expired_at"
+	}
+	if s.softDelete {
+		columns += ", deleted_at"
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s", columns, s.table())
+	var args []interface{}
+	if s.tenantID != "" {
+		query = fmt.Sprintf("SELECT %s, tenant_id FROM %s WHERE tenant_id=?", columns, s.table())
+		args = append(args, s.tenantID)
+	}
+	query += " ORDER BY id"
+
+	rows, err := s.conn(ctx, s.reader(ctx)).Query(query, args...)
+	if err != nil {
+		return ctxErr(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var item StoreItem
+		var code, access, refresh, data, userID, clientID sql.NullString
+		var id interface{} = &item.ID
+		if s.uuidKeys {
+			id = &item.UUID
+		}
+		dest := []interface{}{id, &item.ExpiredAt, &code, &access, &refresh, &data, &userID}
+		if s.clientIDColumn {
+			dest = append(dest, &clientID)
+		}
+		if s.splitExpiry {
+			dest = append(dest, &item.AccessExpiredAt)
+		}
+		if s.softDelete {
+			dest = append(dest, &item.DeletedAt)
+		}
+		if s.tenantID != "" {
+			dest = append(dest, &item.TenantID)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return fmt.Errorf("mysql: scan token row: %w", err)
+		}
+		item.Code, item.Access, item.Refresh = code.String, access.String, refresh.String
+		item.Data, item.UserID, item.ClientID = data.String, userID.String, clientID.String
+		if err := fn(&item); err != nil {
+			return err
+		}
+	}
+	return ctxErr(ctx, rows.Err())
 }
 
 func (s *Store) errorf(format string, args ...interface{}) {
-	if s.stdout != nil {
-		buf := fmt.Sprintf("[OAUTH2-MYSQL-ERROR]: "+format, args...)
-		_, _ = s.stdout.Write([]byte(buf))
+	if logger := s.currentLogger(); logger != nil {
+		logger.Errorf(format, args...)
+	}
+}
+
+func (s *Store) infof(format string, args ...interface{}) {
+	if logger, ok := s.currentLogger().(InfoLogger); ok && s.logLevel <= LogLevelInfo {
+		logger.Infof(format, args...)
+	}
+}
+
+func (s *Store) debugf(format string, args ...interface{}) {
+	if logger, ok := s.currentLogger().(DebugLogger); ok && s.logLevel <= LogLevelDebug {
+		logger.Debugf(format, args...)
 	}
 }
 
-// Create create and store the new token information
+// ctxErr reports the context error instead of err when the query was
+// abandoned because ctx was cancelled or its deadline exceeded, so callers
+// can tell context.Canceled and context.DeadlineExceeded from db errors.
+func ctxErr(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// Create create and store the new token information,
+// a token that already expired is rejected with ErrTokenExpired,
+// one with a 0 lifetime never expires
 func (s *Store) Create(ctx context.Context, info oauth2.TokenInfo) error {
-	buf, _ := jsoniter.Marshal(info)
+	ctx, op := s.startOp(ctx, "Create")
+	err := s.create(ctx, info)
+	op.end(err)
+	return err
+}
+
+func (s *Store) create(ctx context.Context, info oauth2.TokenInfo) error {
+	item, err := s.newItem(info)
+	if err != nil {
+		return err
+	}
+	err = s.retry(ctx, func() error {
+		return s.conn(ctx, s.db).Insert(s.row(item))
+	})
+	if err == nil {
+		s.auditCreate(item)
+	}
+	return ctxErr(ctx, err)
+}
+
+// CreateIfAbsent stores the new token information unless a row with the
+// same token already exists, reporting whether a row was inserted. With
+// WithUniqueTokens a Create retried after a timeout can use it to succeed
+// when the first attempt was in fact stored. Without unique indexes there
+// is nothing to conflict with and the token is always inserted.
+func (s *Store) CreateIfAbsent(ctx context.Context, info oauth2.TokenInfo) (bool, error) {
+	ctx, op := s.startOp(ctx, "CreateIfAbsent")
+	inserted, err := s.createIfAbsent(ctx, info)
+	if inserted {
+		op.setRowsAffected(1)
+	}
+	op.end(err)
+	return inserted, err
+}
+
+func (s *Store) createIfAbsent(ctx context.Context, info oauth2.TokenInfo) (bool, error) {
+	item, err := s.newItem(info)
+	if err != nil {
+		return false, err
+	}
+	values, err := s.insertValues(item)
+	if err != nil {
+		return false, err
+	}
+	columns, row := s.insertColumns()
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s ON CONFLICT DO NOTHING", s.table(), columns, row)
+	if s.isMySQL() {
+		// reports 0 affected rows for a duplicate, unlike INSERT IGNORE it
+		// does not turn other errors such as truncated data into warnings
+		query = fmt.Sprintf("INSERT INTO %s (%s) VALUES %s ON DUPLICATE KEY UPDATE id=id", s.table(), columns, row)
+	}
+
+	var n int64
+	err = s.retry(ctx, func() error {
+		res, err := s.conn(ctx, s.db).Exec(query, values...)
+		if err != nil {
+			return err
+		}
+		n, err = res.RowsAffected()
+		return err
+	})
+	if err == nil && n > 0 {
+		s.auditCreate(item)
+	}
+	return n > 0, ctxErr(ctx, err)
+}
+
+// Update replaces the stored token information in place, matching the row
+// by the token's code, or else its access or refresh token, and inserting
+// it when no row matches. The row keeps its id, so there is no moment
+// where the token is missing, as there is with a remove followed by Create.
+func (s *Store) Update(ctx context.Context, info oauth2.TokenInfo) error {
+	ctx, op := s.startOp(ctx, "Update")
+	err := s.update(ctx, info)
+	op.end(err)
+	return err
+}
+
+func (s *Store) update(ctx context.Context, info oauth2.TokenInfo) error {
+	item, err := s.newItem(info)
+	if err != nil {
+		return err
+	}
+
+	column, value := "code", item.Code
+	if value == "" {
+		column, value = "access", item.Access
+	}
+	if value == "" {
+		column, value = "refresh", item.Refresh
+	}
+
+	tx, err := s.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	// lock the matching row, or the gap it would be inserted in, a
+	// revoked row stays revoked
+	where, args := s.scope(s.notDeleted(column+"=?"), value)
+	query := fmt.Sprintf("SELECT id FROM %s WHERE %s LIMIT 1", s.table(), where)
+	if s.isMySQL() {
+		query += " FOR UPDATE"
+	}
+	ex := s.conn(ctx, tx)
+	var found bool
+	if s.uuidKeys {
+		var id sql.NullString
+		if id, err = ex.SelectNullStr(query, args...); id.Valid {
+			found, item.UUID = true, id.String
+		}
+	} else {
+		var id sql.NullInt64
+		if id, err = ex.SelectNullInt(query, args...); id.Valid {
+			found, item.ID = true, id.Int64
+		}
+	}
+	if err == nil {
+		if found {
+			_, err = ex.Update(s.row(item))
+		} else {
+			err = ex.Insert(s.row(item))
+		}
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return ctxErr(ctx, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return ctxErr(ctx, err)
+	}
+	s.auditCreate(item)
+	return nil
+}
+
+// CreateBatch create and store the token information in bulk, such as
+// when importing tokens from another store. The rows are written with
+// multi-row inserts of up to the insert batch size, all within one
+// transaction so either every token is stored or none is.
+func (s *Store) CreateBatch(ctx context.Context, infos []oauth2.TokenInfo) error {
+	ctx, op := s.startOp(ctx, "CreateBatch")
+	err := s.createBatch(ctx, infos)
+	op.end(err)
+	return err
+}
+
+func (s *Store) createBatch(ctx context.Context, infos []oauth2.TokenInfo) error {
+	items := make([]*StoreItem, len(infos))
+	for i, info := range infos {
+		item, err := s.newItem(info)
+		if err != nil {
+			return fmt.Errorf("mysql: token %d: %w", i, err)
+		}
+		items[i] = item
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	columns, row := s.insertColumns()
+	prefix := fmt.Sprintf("INSERT INTO %s (%s) VALUES ", s.table(), columns)
+
+	tx, err := s.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(items); start += s.insertBatchSize {
+		end := start + s.insertBatchSize
+		if end > len(items) {
+			end = len(items)
+		}
+
+		rows := make([]string, 0, end-start)
+		var args []interface{}
+		for _, item := range items[start:end] {
+			rows = append(rows, row)
+			values, err := s.insertValues(item)
+			if err != nil {
+				_ = tx.Rollback()
+				return err
+			}
+			args = append(args, values...)
+		}
+
+		if _, err := s.conn(ctx, tx).Exec(prefix+strings.Join(rows, ","), args...); err != nil {
+			_ = tx.Rollback()
+			return ctxErr(ctx, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return ctxErr(ctx, err)
+	}
+	for _, item := range items {
+		s.auditCreate(item)
+	}
+	return nil
+}
+
+// insertColumns returns the quoted column list of a plain INSERT
+// and the placeholders of one row
+func (s *Store) insertColumns() (string, string) {
+	columns := []string{"expired_at", "code", "access", "refresh", "data", "user_id"}
+	if s.uuidKeys {
+		columns = append([]string{"id"}, columns...)
+	}
+	if s.clientIDColumn {
+		columns = append(columns, "client_id")
+	}
+	if s.splitExpiry {
+		columns = append(columns, "access_expired_at")
+	}
+	if s.softDelete {
+		columns = append(columns, "deleted_at")
+	}
+	if s.tenantID != "" {
+		columns = append(columns, "tenant_id")
+	}
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = s.db.Dialect.QuoteField(column)
+	}
+	return strings.Join(quoted, ","), "(" + strings.TrimSuffix(strings.Repeat("?,", len(columns)), ",") + ")"
+}
+
+// insertValues returns the values of the item for insertColumns,
+// converted like gorp does for its own inserts
+func (s *Store) insertValues(item *StoreItem) ([]interface{}, error) {
+	values := []interface{}{item.ExpiredAt, item.Code, item.Access, item.Refresh, item.Data, item.UserID}
+	if s.uuidKeys {
+		values = append([]interface{}{item.UUID}, values...)
+	}
+	if s.clientIDColumn {
+		values = append(values, item.ClientID)
+	}
+	if s.splitExpiry {
+		values = append(values, item.AccessExpiredAt)
+	}
+	if s.softDelete {
+		values = append(values, item.DeletedAt)
+	}
+	if s.tenantID != "" {
+		values = append(values, item.TenantID)
+	}
+	if s.db.TypeConverter != nil {
+		for i, value := range values {
+			converted, err := s.db.TypeConverter.ToDb(value)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = converted
+		}
+	}
+	return values, nil
+}
+
+// Begin starts a transaction on the store's database,
+// to be used with CreateTx and committed or rolled back by the caller
+func (s *Store) Begin(ctx context.Context) (*gorp.Transaction, error) {
+	tx, err := s.db.WithContext(ctx).(*gorp.DbMap).Begin()
+	return tx, ctxErr(ctx, err)
+}
+
+// CreateTx create and store the new token information within the
+// transaction, so it commits or rolls back together with the caller's
+// other writes. The transaction must come from Begin or from a gorp.DbMap
+// the token table is registered on. Removing tokens created in a
+// transaction that is still open must go through the same transaction.
+func (s *Store) CreateTx(ctx context.Context, tx *gorp.Transaction, info oauth2.TokenInfo) error {
+	item, err := s.newItem(info)
+	if err != nil {
+		return err
+	}
+	return ctxErr(ctx, s.conn(ctx, tx).Insert(s.row(item)))
+}
+
+func (s *Store) newItem(info oauth2.TokenInfo) (*StoreItem, error) {
+	buf, err := s.codec.Marshal(info)
+	if err != nil {
+		return nil, fmt.Errorf("mysql: marshal token: %w", err)
+	}
+	data, err := s.encodeData(buf)
+	if err != nil {
+		return nil, err
+	}
 	item := &StoreItem{
-		Data: string(buf),
+		Data: data,
+	}
+	if s.uuidKeys {
+		if item.UUID, err = newUUID(); err != nil {
+			return nil, err
+		}
 	}
 
 	item.UserID = info.GetUserID()
+	item.TenantID = s.tenantID
+	item.ClientID = info.GetClientID()
 
 	if code := info.GetCode(); code != "" {
 		item.Code = code
-		item.ExpiredAt = info.GetCodeCreateAt().Add(info.GetCodeExpiresIn()).Unix()
+		item.ExpiredAt = expiryUnix(info.GetCodeCreateAt(), info.GetCodeExpiresIn())
 	} else {
 		item.Access = info.GetAccess()
-		item.ExpiredAt = info.GetAccessCreateAt().Add(info.GetAccessExpiresIn()).Unix()
+		item.ExpiredAt = expiryUnix(info.GetAccessCreateAt(), info.GetAccessExpiresIn())
+		if s.splitExpiry {
+			item.AccessExpiredAt = item.ExpiredAt
+		}
 
 		if refresh := info.GetRefresh(); refresh != "" {
 			item.Refresh = info.GetRefresh()
-			item.ExpiredAt = info.GetRefreshCreateAt().Add(info.GetRefreshExpiresIn()).Unix()
+			item.ExpiredAt = expiryUnix(info.GetRefreshCreateAt(), info.GetRefreshExpiresIn())
 		}
 	}
 
-	return s.db.Insert(item)
+	// the gc would delete the row on its next cycle
+	if expiredAt := time.Unix(item.ExpiredAt, 0); item.ExpiredAt != neverExpires && !expiredAt.After(s.now()) {
+		return nil, fmt.Errorf("%w: expired at %s", ErrTokenExpired, expiredAt.UTC().Format(time.RFC3339))
+	}
+	return item, nil
+}
+
+// neverExpires expired_at of the tokens without a lifetime, which oauth2
+// sets to 0 for tokens that never expire. The gc and the lookups compare
+// it like any other expiry, it is never reached.
+const neverExpires int64 = math.MaxInt64
+
+// expiryUnix returns the unix expiry of a token, neverExpires when it has
+// no lifetime
+func expiryUnix(createAt time.Time, expiresIn time.Duration) int64 {
+	if expiresIn <= 0 {
+		return neverExpires
+	}
+	return createAt.Add(expiresIn).Unix()
+}
+
+// Touch extends the expiry of the access token to expiry without
+// rewriting its token information, for sliding sessions, reporting
+// whether an unexpired row of the token was updated. The expiry of a row
+// also holding a longer lived refresh token is left alone. Only the
+// store expiry moves: the oauth2 manager still checks the expiry of the
+// token information it reads.
+func (s *Store) Touch(ctx context.Context, access string, expiry time.Time) (bool, error) {
+	if access == "" {
+		return false, nil
+	}
+	now := s.now()
+	if !expiry.After(now) {
+		return false, fmt.Errorf("%w: expired at %s", ErrTokenExpired, expiry.UTC().Format(time.RFC3339))
+	}
+	ctx, op := s.startOp(ctx, "Touch")
+	n, err := s.touch(ctx, access, expiry.Unix(), now.Unix())
+	found := n > 0
+	if err == nil && !found {
+		// MySQL only counts the rows whose values changed
+		found, err = s.touchedRow(ctx, access, now.Unix())
+	}
+	op.setRowsAffected(n)
+	op.end(err)
+	return found, err
+}
+
+func (s *Store) touch(ctx context.Context, access string, expiry, now int64) (int64, error) {
+	args := []interface{}{expiry, expiry, access, now}
+	if s.splitExpiry {
+		args = append([]interface{}{expiry}, args...)
+	}
+	res, err := s.conn(ctx, s.db).Exec(s.queries.touch, s.scopeArgs(args...)...)
+	if err != nil {
+		return 0, ctxErr(ctx, err)
+	}
+	return res.RowsAffected()
+}
+
+// touchedRow reports whether a row matches the touch of the access token,
+// with the same expiry column
+func (s *Store) touchedRow(ctx context.Context, access string, now int64) (bool, error) {
+	found, err := s.conn(ctx, s.db).SelectNullInt(s.queries.touched, s.scopeArgs(access, now)...)
+	return found.Valid, ctxErr(ctx, err)
 }
 
 // RemoveByCode delete the authorization code
 func (s *Store) RemoveByCode(ctx context.Context, code string) error {
-	query := fmt.Sprintf("UPDATE %s SET code='' WHERE code=? LIMIT 1", s.tableName)
-	_, err := s.db.Exec(query, code)
-	if err != nil && err == sql.ErrNoRows {
-		return nil
-	}
+	_, err := s.RemoveByCodeCount(ctx, code)
 	return err
 }
 
+// RemoveByCodeCount is RemoveByCode also returning the number of rows
+// removed, 0 when no row holds the token or it was already removed
+func (s *Store) RemoveByCodeCount(ctx context.Context, code string) (int64, error) {
+	ctx, op := s.startOp(ctx, "RemoveByCode")
+	n, err := s.remove(ctx, "code", code)
+	op.setRowsAffected(n)
+	op.end(err)
+	return n, err
+}
+
 // RemoveByAccess use the access token to delete the token information
 func (s *Store) RemoveByAccess(ctx context.Context, access string) error {
-	query := fmt.Sprintf("UPDATE %s SET access='' WHERE access=? LIMIT 1", s.tableName)
-	_, err := s.db.Exec(query, access)
-	if err != nil && err == sql.ErrNoRows {
-		return nil
-	}
+	_, err := s.RemoveByAccessCount(ctx, access)
 	return err
 }
 
+// RemoveByAccessCount is RemoveByAccess also returning the number of rows
+// removed, 0 when no row holds the token or it was already removed
+func (s *Store) RemoveByAccessCount(ctx context.Context, access string) (int64, error) {
+	ctx, op := s.startOp(ctx, "RemoveByAccess")
+	n, err := s.remove(ctx, "access", access)
+	op.setRowsAffected(n)
+	op.end(err)
+	return n, err
+}
+
 // RemoveByRefresh use the refresh token to delete the token information
 func (s *Store) RemoveByRefresh(ctx context.Context, refresh string) error {
-	query := fmt.Sprintf("UPDATE %s SET refresh='' WHERE refresh=? LIMIT 1", s.tableName)
-	_, err := s.db.Exec(query, refresh)
-	if err != nil && err == sql.ErrNoRows {
-		return nil
-	}
+	_, err := s.RemoveByRefreshCount(ctx, refresh)
 	return err
 }
 
-func (s *Store) toTokenInfo(data string) oauth2.TokenInfo {
-	var tm models.Token
-	_ = jsoniter.Unmarshal([]byte(data), &tm)
-	return &tm
+// RemoveByRefreshCount is RemoveByRefresh also returning the number of rows
+// removed, 0 when no row holds the token or it was already removed
+func (s *Store) RemoveByRefreshCount(ctx context.Context, refresh string) (int64, error) {
+	ctx, op := s.startOp(ctx, "RemoveByRefresh")
+	n, err := s.remove(ctx, "refresh", refresh)
+	op.setRowsAffected(n)
+	op.end(err)
+	return n, err
 }
 
-// GetByCode use the authorization code for token information data
-func (s *Store) GetByCode(ctx context.Context, code string) (oauth2.TokenInfo, error) {
-	if code == "" {
-		return nil, nil
+// remove clears the token column of the matching row,
+// or deletes the whole row when hard delete is enabled,
+// returning the number of rows changed. Tokens are assumed unique,
+// so there is no (MySQL only) LIMIT 1: should a token ever be stored
+// twice every copy is removed, none of them stays usable.
+func (s *Store) remove(ctx context.Context, column, value string) (int64, error) {
+	res, err := s.conn(ctx, s.db).Exec(s.queries.remove[column], s.removeArgs(value)...)
+	if err != nil {
+		return 0, ctxErr(ctx, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 && s.notFoundError {
+		return 0, ErrNotFound
+	}
+	if n > 0 {
+		s.auditRemove(column, value)
+	}
+	return n, nil
+}
+
+// RemoveByUserID delete every token row of the user, such as when the
+// account is compromised, returning the number of rows deleted.
+// The lookup uses the user_id column and its idx_user_id index.
+func (s *Store) RemoveByUserID(ctx context.Context, userID string) (int64, error) {
+	if userID == "" {
+		return 0, nil
 	}
+	ctx, op := s.startOp(ctx, "RemoveByUserID")
+	n, err := s.removeRows(ctx, "user_id", userID)
+	op.setRowsAffected(n)
+	op.end(err)
+	return n, err
+}
 
-	query := fmt.Sprintf("SELECT * FROM %s WHERE code=? LIMIT 1", s.tableName)
-	var item StoreItem
-	err := s.db.SelectOne(&item, query, code)
+// RemoveByClientID delete every token row issued to the client, returning
+// the number of rows deleted. It requires WithClientIDColumn.
+func (s *Store) RemoveByClientID(ctx context.Context, clientID string) (int64, error) {
+	if !s.clientIDColumn {
+		return 0, errors.New("mysql: remove by client id: the client_id column requires WithClientIDColumn")
+	}
+	if clientID == "" {
+		return 0, nil
+	}
+	ctx, op := s.startOp(ctx, "RemoveByClientID")
+	n, err := s.removeRows(ctx, "client_id", clientID)
+	op.setRowsAffected(n)
+	op.end(err)
+	return n, err
+}
+
+// RevokeByClientID revoke every token issued to the client when it is
+// deprovisioned, an alias of RemoveByClientID whose count can be kept
+// for audit logs
+func (s *Store) RevokeByClientID(ctx context.Context, clientID string) (int64, error) {
+	return s.RemoveByClientID(ctx, clientID)
+}
+
+// removeRows deletes all the rows with the column value,
+// or revokes them in soft delete mode
+func (s *Store) removeRows(ctx context.Context, column, value string) (int64, error) {
+	where, _ := s.scope(s.notDeleted(column + "=?"))
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s", s.table(), where)
+	if s.softDelete {
+		query = fmt.Sprintf("UPDATE %s SET deleted_at=? WHERE %s", s.table(), where)
+	}
+	res, err := s.conn(ctx, s.db).Exec(query, s.removeArgs(value)...)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
+		return 0, ctxErr(ctx, err)
+	}
+	n, err := res.RowsAffected()
+	if err == nil && n > 0 {
+		s.auditRemove(column, value)
+	}
+	return n, err
+}
+
+// encodeData turns the marshaled token into the stored Data value,
+// compressing and/or encrypting it and then base64 encoding it
+// when compression or a cipher is configured
+func (s *Store) encodeData(buf []byte) (string, error) {
+	if !s.compress && s.cipher == nil {
+		return s.checkDataSize(string(buf))
+	}
+
+	if s.compress {
+		var b bytes.Buffer
+		zw := gzip.NewWriter(&b)
+		if _, err := zw.Write(buf); err != nil {
+			return "", fmt.Errorf("mysql: compress token: %w", err)
 		}
-		return nil, err
+		if err := zw.Close(); err != nil {
+			return "", fmt.Errorf("mysql: compress token: %w", err)
+		}
+		buf = b.Bytes()
 	}
-	return s.toTokenInfo(item.Data), nil
+
+	if s.cipher != nil {
+		ciphertext, err := s.cipher.Encrypt(buf)
+		if err != nil {
+			return "", fmt.Errorf("mysql: encrypt token: %w", err)
+		}
+		buf = ciphertext
+	}
+	return s.checkDataSize(base64.StdEncoding.EncodeToString(buf))
 }
 
-// GetByAccess use the access token for token information data
-func (s *Store) GetByAccess(ctx context.Context, access string) (oauth2.TokenInfo, error) {
-	if access == "" {
-		return nil, nil
+// checkDataSize rejects data that does not fit the Data column, which
+// gorp maps to VARCHAR(DataSize) or to a TEXT column holding 65535 bytes
+func (s *Store) checkDataSize(data string) (string, error) {
+	if s.sizes.Data <= maxVarcharSize {
+		if n := utf8.RuneCountInString(data); n > s.sizes.Data {
+			return "", fmt.Errorf("%w: %d characters, the column holds %d", ErrDataTooLong, n, s.sizes.Data)
+		}
+		return data, nil
 	}
 
-	query := fmt.Sprintf("SELECT * FROM %s WHERE access=? LIMIT 1", s.tableName)
-	var item StoreItem
-	err := s.db.SelectOne(&item, query, access)
+	const maxTextBytes = 65535
+	if len(data) > maxTextBytes {
+		return "", fmt.Errorf("%w: %d bytes, the column holds %d", ErrDataTooLong, len(data), maxTextBytes)
+	}
+	return data, nil
+}
+
+// decodeData reverses encodeData. Plain JSON rows written before
+// compression was enabled are still read as is.
+func (s *Store) decodeData(data string) ([]byte, error) {
+	if s.cipher == nil && (!s.compress || strings.HasPrefix(data, "{")) {
+		return []byte(data), nil
+	}
+
+	buf, err := base64.StdEncoding.DecodeString(data)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
+		return nil, fmt.Errorf("mysql: decode token: %w", err)
+	}
+
+	if s.cipher != nil {
+		buf, err = s.cipher.Decrypt(buf)
+		if err != nil {
+			return nil, fmt.Errorf("mysql: decrypt token: %w", err)
+		}
+	}
+
+	// gzip magic number, JSON never starts with it
+	if len(buf) > 1 && buf[0] == 0x1f && buf[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(buf))
+		if err != nil {
+			return nil, fmt.Errorf("mysql: decompress token: %w", err)
+		}
+		defer zr.Close()
+		buf, err = io.ReadAll(zr)
+		if err != nil {
+			return nil, fmt.Errorf("mysql: decompress token: %w", err)
+		}
+	}
+	return buf, nil
+}
+
+// itemTokenInfo decodes the token information of the row, logging and
+// returning ErrCorruptToken when the row can't pass for a token
+func (s *Store) itemTokenInfo(item *StoreItem) (oauth2.TokenInfo, error) {
+	info, err := s.toTokenInfo(item.Data)
+	if err == nil && info.GetCode() == "" && info.GetAccess() == "" && info.GetRefresh() == "" {
+		err = errors.New("no code, access or refresh token")
+	}
+	if err != nil {
+		id := item.UUID
+		if !s.uuidKeys {
+			id = strconv.FormatInt(item.ID, 10)
 		}
+		// the decode errors may quote the data, tokens included
+		err = s.redactErr(err, item.Code, item.Access, item.Refresh)
+		s.errorf("mysql: token row %s: %s", id, err)
+		return nil, fmt.Errorf("%w: row %s: %s", ErrCorruptToken, id, err)
+	}
+	return info, nil
+}
+
+func (s *Store) toTokenInfo(data string) (oauth2.TokenInfo, error) {
+	buf, err := s.decodeData(data)
+	if err != nil {
 		return nil, err
 	}
-	return s.toTokenInfo(item.Data), nil
+
+	var tm oauth2.TokenInfo
+	if s.tokenFactory != nil {
+		tm = s.tokenFactory()
+	} else {
+		tm = models.NewToken()
+	}
+	if err := s.codec.Unmarshal(buf, tm); err != nil {
+		return nil, fmt.Errorf("mysql: unmarshal token: %w", err)
+	}
+	return tm, nil
+}
+
+// GetByCode use the authorization code for token information data
+func (s *Store) GetByCode(ctx context.Context, code string) (oauth2.TokenInfo, error) {
+	if code == "" {
+		return nil, s.notFound()
+	}
+	ctx, op := s.startOp(ctx, "GetByCode")
+	info, err := s.getTokenInfo(ctx, "code", code)
+	op.end(err)
+	return info, err
+}
+
+// GetByAccess use the access token for token information data
+func (s *Store) GetByAccess(ctx context.Context, access string) (oauth2.TokenInfo, error) {
+	if access == "" {
+		return nil, s.notFound()
+	}
+	ctx, op := s.startOp(ctx, "GetByAccess")
+	info, err := s.getTokenInfo(ctx, "access", access)
+	op.end(err)
+	return info, err
 }
 
 // GetByRefresh use the refresh token for token information data
 func (s *Store) GetByRefresh(ctx context.Context, refresh string) (oauth2.TokenInfo, error) {
+	if refresh == "" {
+		return nil, s.notFound()
+	}
+	ctx, op := s.startOp(ctx, "GetByRefresh")
+	info, err := s.getTokenInfo(ctx, "refresh", refresh)
+	op.end(err)
+	return info, err
+}
+
+// ExistsByCode reports whether the authorization code is stored and
+// has not expired, without decoding the token information
+func (s *Store) ExistsByCode(ctx context.Context, code string) (bool, error) {
+	if code == "" {
+		return false, nil
+	}
+	ctx, op := s.startOp(ctx, "ExistsByCode")
+	ok, err := s.exists(ctx, "code", code)
+	op.end(err)
+	return ok, err
+}
+
+// ExistsByAccess reports whether the access token is stored and
+// has not expired, without decoding the token information
+func (s *Store) ExistsByAccess(ctx context.Context, access string) (bool, error) {
+	if access == "" {
+		return false, nil
+	}
+	ctx, op := s.startOp(ctx, "ExistsByAccess")
+	ok, err := s.exists(ctx, "access", access)
+	op.end(err)
+	return ok, err
+}
+
+// ExistsByRefresh reports whether the refresh token is stored and
+// has not expired, without decoding the token information
+func (s *Store) ExistsByRefresh(ctx context.Context, refresh string) (bool, error) {
+	if refresh == "" {
+		return false, nil
+	}
+	ctx, op := s.startOp(ctx, "ExistsByRefresh")
+	ok, err := s.exists(ctx, "refresh", refresh)
+	op.end(err)
+	return ok, err
+}
+
+func (s *Store) exists(ctx context.Context, column, value string) (bool, error) {
+	// the access token expires apart from its row, see buildQueries
+	expiry := "expired_at"
+	if column == "access" && s.splitExpiry {
+		expiry = "access_expired_at"
+	}
+	where, args := s.scope(s.notDeleted(column+"=? AND "+expiry+">?"), value, s.now().Unix())
+	query := fmt.Sprintf("SELECT 1 FROM %s WHERE %s LIMIT 1", s.table(), where)
+	var found sql.NullInt64
+	err := s.retry(ctx, func() error {
+		var err error
+		found, err = s.conn(ctx, s.reader(ctx)).SelectNullInt(query, args...)
+		return err
+	})
+	return found.Valid, ctxErr(ctx, err)
+}
+
+// GetByID use the primary key for token information data
+func (s *Store) GetByID(ctx context.Context, id int64) (oauth2.TokenInfo, error) {
+	ctx, op := s.startOp(ctx, "GetByID")
+	info, err := s.getTokenInfo(ctx, "id", id)
+	op.end(err)
+	return info, err
+}
+
+// GetItemByID use the primary key for the raw stored row,
+// returns nil when no row matches
+func (s *Store) GetItemByID(ctx context.Context, id int64) (*StoreItem, error) {
+	ctx, op := s.startOp(ctx, "GetItemByID")
+	item, err := s.getItem(ctx, "id", id)
+	op.end(err)
+	return item, err
+}
+
+// GetItemByCode use the authorization code for the raw stored row,
+// returns nil when no row matches
+func (s *Store) GetItemByCode(ctx context.Context, code string) (*StoreItem, error) {
+	if code == "" {
+		return nil, nil
+	}
+	ctx, op := s.startOp(ctx, "GetItemByCode")
+	item, err := s.getItem(ctx, "code", code)
+	op.end(err)
+	return item, err
+}
+
+// GetItemByAccess use the access token for the raw stored row,
+// returns nil when no row matches
+func (s *Store) GetItemByAccess(ctx context.Context, access string) (*StoreItem, error) {
+	if access == "" {
+		return nil, nil
+	}
+	ctx, op := s.startOp(ctx, "GetItemByAccess")
+	item, err := s.getItem(ctx, "access", access)
+	op.end(err)
+	return item, err
+}
+
+// GetItemByRefresh use the refresh token for the raw stored row,
+// returns nil when no row matches
+func (s *Store) GetItemByRefresh(ctx context.Context, refresh string) (*StoreItem, error) {
 	if refresh == "" {
 		return nil, nil
 	}
+	ctx, op := s.startOp(ctx, "GetItemByRefresh")
+	item, err := s.getItem(ctx, "refresh", refresh)
+	op.end(err)
+	return item, err
+}
+
+// getTokenInfo skips expired rows the gc has not deleted yet
+// unless the store reads expired tokens
+func (s *Store) getTokenInfo(ctx context.Context, column string, value interface{}) (oauth2.TokenInfo, error) {
+	args := []interface{}{value}
+	if !s.readExpired {
+		args = append(args, s.now().Unix())
+	}
+	item, err := s.selectItem(ctx, s.queries.getToken[column], s.scopeArgs(args...)...)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return nil, s.notFound()
+	}
+	return s.itemTokenInfo(item)
+}
+
+// notFound returns the error of a lookup missing, nil unless
+// WithTokenNotFoundError is enabled
+func (s *Store) notFound() error {
+	if s.tokenNotFound {
+		return ErrTokenNotFound
+	}
+	return nil
+}
+
+// getItem selects the raw row by lookup column, expired or not
+func (s *Store) getItem(ctx context.Context, column string, value interface{}) (*StoreItem, error) {
+	return s.selectItem(ctx, s.queries.getItem[column], s.scopeArgs(value)...)
+}
 
-	query := fmt.Sprintf("SELECT * FROM %s WHERE refresh=? LIMIT 1", s.tableName)
+// selectItem runs a query of a single token row
+func (s *Store) selectItem(ctx context.Context, query string, args ...interface{}) (*StoreItem, error) {
 	var item StoreItem
-	err := s.db.SelectOne(&item, query, refresh)
+	var uuidItem UUIDStoreItem
+	err := s.retry(ctx, func() error {
+		if s.uuidKeys {
+			return s.conn(ctx, s.reader(ctx)).SelectOne(&uuidItem, query, args...)
+		}
+		return s.conn(ctx, s.reader(ctx)).SelectOne(&item, query, args...)
+	})
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
-		return nil, err
+		return nil, ctxErr(ctx, err)
+	}
+	if s.uuidKeys {
+		item = uuidItem.storeItem()
 	}
-	return s.toTokenInfo(item.Data), nil
+	return &item, nil
 }
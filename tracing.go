@@ -0,0 +1,68 @@
+package mysql
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// noopSpan is used when no tracer is configured
+var noopSpan = trace.SpanFromContext(context.Background())
+
+// operation a store operation being traced and timed
+type operation struct {
+	store *Store
+	name  string
+	start time.Time
+	span  trace.Span
+	// cancel releases the query timeout of the operation
+	cancel context.CancelFunc
+}
+
+// startOp starts the operation, with a span named mysql.store.<name>
+// when a tracer is configured, bounded by the query timeout when set
+func (s *Store) startOp(ctx context.Context, name string) (context.Context, *operation) {
+	ctx, op := s.traceOp(ctx, name)
+	if s.queryTimeout > 0 {
+		// keeps the caller's deadline when it is the earlier one
+		ctx, op.cancel = context.WithTimeout(ctx, s.queryTimeout)
+	}
+	return ctx, op
+}
+
+// traceOp starts the operation like startOp, without the query timeout
+func (s *Store) traceOp(ctx context.Context, name string) (context.Context, *operation) {
+	op := &operation{store: s, name: name, start: time.Now(), span: noopSpan}
+	if s.tracer != nil {
+		ctx, op.span = s.tracer.Start(ctx, "mysql.store."+name,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("db.system", "mysql"),
+				attribute.String("db.sql.table", s.tableName),
+			),
+		)
+	}
+	return ctx, op
+}
+
+// setRowsAffected records the number of rows a statement changed on the span
+func (op *operation) setRowsAffected(n int64) {
+	op.span.SetAttributes(attribute.Int64("db.rows_affected", n))
+}
+
+// end ends the span, marking it failed when err is set,
+// and reports the duration to the metrics hook
+func (op *operation) end(err error) {
+	if err != nil {
+		op.span.RecordError(err)
+		op.span.SetStatus(codes.Error, err.Error())
+	}
+	op.span.End()
+	op.store.observeQuery(op.name, time.Since(op.start), err)
+	if op.cancel != nil {
+		op.cancel()
+	}
+}
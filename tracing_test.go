@@ -0,0 +1,76 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newTracedMockStore(t *testing.T) (*Store, sqlmock.Sqlmock, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	store, mockDB := newMockStore(t, WithTracer(provider.Tracer("test")))
+	return store, mockDB, recorder
+}
+
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestRemoveByAccess_ShouldRecordSpan(t *testing.T) {
+	// ARRANGE
+	store, mockDB, recorder := newTracedMockStore(t)
+	defer store.Close()
+
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE `oauth2_token` SET access='' WHERE access=?")).
+		WithArgs("1_1_1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// ACTION
+	err := store.RemoveByAccess(context.Background(), "1_1_1")
+
+	// ASSERT
+	assert.NoError(t, err)
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, "mysql.store.RemoveByAccess", spans[0].Name())
+		attrs := spanAttributes(spans[0])
+		assert.Equal(t, "mysql", attrs["db.system"].AsString())
+		assert.Equal(t, "oauth2_token", attrs["db.sql.table"].AsString())
+		assert.Equal(t, int64(1), attrs["db.rows_affected"].AsInt64())
+		assert.Equal(t, codes.Unset, spans[0].Status().Code)
+	}
+}
+
+func TestGetByCode_ShouldRecordSpanError(t *testing.T) {
+	// ARRANGE
+	store, mockDB, recorder := newTracedMockStore(t)
+	defer store.Close()
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE code=? AND expired_at>? LIMIT 1")).
+		WillReturnError(errors.New("boom"))
+
+	// ACTION
+	_, err := store.GetByCode(context.Background(), "11_11_11")
+
+	// ASSERT
+	assert.Error(t, err)
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, "mysql.store.GetByCode", spans[0].Name())
+		assert.Equal(t, codes.Error, spans[0].Status().Code)
+		assert.Equal(t, "boom", spans[0].Status().Description)
+	}
+}
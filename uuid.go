@@ -0,0 +1,74 @@
+package mysql
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// UUIDStoreItem data item of a token table keyed by UUIDs the store
+// generates instead of an autoincrement id, see WithUUIDKeys
+type UUIDStoreItem struct {
+	ID        string `db:"id,primarykey,size:36"`
+	ExpiredAt int64  `db:"expired_at"`
+	Code      string `db:"code,size:255"`
+	Access    string `db:"access,size:255"`
+	Refresh   string `db:"refresh,size:255"`
+	Data      string `db:"data,size:2048"`
+	UserID    string `db:"user_id,size:16"`
+	TenantID  string `db:"tenant_id,size:64"`
+	ClientID  string `db:"client_id,size:128"`
+	// AccessExpiredAt see StoreItem.AccessExpiredAt
+	AccessExpiredAt int64 `db:"access_expired_at"`
+	// DeletedAt see StoreItem.DeletedAt
+	DeletedAt int64 `db:"deleted_at"`
+}
+
+// newUUID returns a random version 4 UUID
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("mysql: generate uuid: %w", err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// row returns the value gorp maps to the token table for the item
+func (s *Store) row(item *StoreItem) interface{} {
+	if !s.uuidKeys {
+		return item
+	}
+	return &UUIDStoreItem{
+		ID:        item.UUID,
+		ExpiredAt: item.ExpiredAt,
+		Code:      item.Code,
+		Access:    item.Access,
+		Refresh:   item.Refresh,
+		Data:      item.Data,
+		UserID:    item.UserID,
+		TenantID:  item.TenantID,
+		ClientID:  item.ClientID,
+
+		AccessExpiredAt: item.AccessExpiredAt,
+		DeletedAt:       item.DeletedAt,
+	}
+}
+
+// storeItem returns the row of a UUID keyed table as a StoreItem
+func (u *UUIDStoreItem) storeItem() StoreItem {
+	return StoreItem{
+		UUID:      u.ID,
+		ExpiredAt: u.ExpiredAt,
+		Code:      u.Code,
+		Access:    u.Access,
+		Refresh:   u.Refresh,
+		Data:      u.Data,
+		UserID:    u.UserID,
+		TenantID:  u.TenantID,
+		ClientID:  u.ClientID,
+
+		AccessExpiredAt: u.AccessExpiredAt,
+		DeletedAt:       u.DeletedAt,
+	}
+}
@@ -0,0 +1,57 @@
+package mysql
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-oauth2/oauth2/v4/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewUUID_ShouldReturnVersion4UUID(t *testing.T) {
+	// ACTION
+	first, err := newUUID()
+	assert.NoError(t, err)
+	second, err := newUUID()
+	assert.NoError(t, err)
+
+	// ASSERT
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, first)
+	assert.NotEqual(t, first, second)
+}
+
+func TestWithUUIDKeys_ShouldCreateTableAndInsertWithUUID(t *testing.T) {
+	// ARRANGE
+	db, mockDB, _ := sqlmock.New()
+	mockDB.ExpectExec(regexp.QuoteMeta("create table if not exists `oauth2_token` (`id` varchar(36) not null primary key, `expired_at` bigint")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDefaultIndexes(mockDB)
+	store := NewStoreWithOpts(db, WithUUIDKeys(true), WithGCTimeInterval(-1))
+	defer store.Close()
+
+	id := &captureArg{}
+	mockDB.ExpectExec(regexp.QuoteMeta("insert into `oauth2_token` (`id`,`expired_at`,`code`,`access`,`refresh`,`data`,`user_id`)")).
+		WithArgs(id, sqlmock.AnyArg(), "", "1_1_1", "", sqlmock.AnyArg(), "1_1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// ACTION
+	err := store.Create(context.Background(), &models.Token{UserID: "1_1", Access: "1_1_1", AccessCreateAt: time.Now(), AccessExpiresIn: time.Hour})
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oauth2_token` WHERE access=? LIMIT 1")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "expired_at", "code", "access", "refresh", "data", "user_id"}).
+			AddRow(id.value, 10, "", "1_1_1", "", `{"Access":"1_1_1"}`, "1_1"))
+	item, getErr := store.GetItemByAccess(context.Background(), "1_1_1")
+
+	// ASSERT
+	assert.NoError(t, err)
+	assert.NoError(t, getErr)
+	assert.Regexp(t, `^[0-9a-f-]{36}$`, id.value)
+	if assert.NotNil(t, item) {
+		assert.Equal(t, id.value, item.UUID)
+		assert.Equal(t, int64(0), item.ID)
+	}
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}